@@ -0,0 +1,265 @@
+package exporters
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// A Collector collects go-metrics metric into Metric, with configurable
+// percentiles and fields, e.g. to report only count, mean and p99 of timers:
+//
+//    collector := &Collector{
+//        Percentiles: []float64{0.99},
+//        Include: map[MetricType][]string{
+//            TypeTimer: {"count", "mean", "p99"},
+//        },
+//    }
+//
+// Fields of each type are:
+//
+//    counter     count
+//    gauge       gauge, including functional gauges
+//    meter       count, m1, m5, m15, mean
+//    histogram   count, max, mean, min, stddev, variance, percentiles, including bare samples
+//    timer       count, max, mean, min, stddev, variance, percentiles, m1, m5, m15, meanrate
+//    ewma        rate
+//    healthcheck healthy (bool), with label error if unhealthy
+//
+// Other metric types can be collected by registering CollectFunc.
+type Collector struct {
+	// Percentiles of timer and histogram, e.g. 0.5, 0.99.
+	Percentiles []float64
+	// Name of percentile field, default to PercentileP, e.g. p99.
+	PercentileName func(q float64) string
+	// Fields to include per type, all fields are included if absent.
+	Include map[MetricType][]string
+	// Fields to exclude per type, applied after include.
+	Exclude map[MetricType][]string
+	// Run health check before collecting healthcheck, otherwise status of last
+	// check is collected, see Registry.RunHealthchecks.
+	RunHealthchecks bool
+	// Custom collect funcs, tried in order before builtin types.
+	Funcs []CollectFunc
+}
+
+// A CollectFunc collects custom metric type, it returns nil if metric is not
+// of the type, thus is left to next func, or builtin types.
+type CollectFunc func(name string, metric any) *Metric
+
+// Register func to collect custom metric type. It must not be called
+// concurrently with Collect, e.g. register before reporter started.
+func (c *Collector) Register(fn CollectFunc) *Collector {
+	c.Funcs = append(c.Funcs, fn)
+	return c
+}
+
+// Collector used by CollectMetric, collects all fields with percentiles
+// 0.5, 0.75, 0.95, 0.99, 0.999, 0.9999.
+var DefaultCollector = &Collector{
+	Percentiles: []float64{0.5, 0.75, 0.95, 0.99, 0.999, 0.9999},
+}
+
+// Collect metric with DefaultCollector, nil if metric is not supported.
+func CollectMetric(name string, metric any) *Metric {
+	return DefaultCollector.Collect(name, metric)
+}
+
+// Collect metric, nil if metric is not supported.
+func (c *Collector) Collect(name string, metric any) *Metric {
+	for _, fn := range c.Funcs {
+		if m := fn(name, metric); m != nil {
+			return c.filter(m)
+		}
+	}
+	now := time.Now()
+	switch metric := metric.(type) {
+	case metrics.Counter:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"count": IntValue(ms.Count()),
+		}
+		return c.metric(name, TypeCounter, now, fields)
+	case metrics.Histogram:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"count":    IntValue(ms.Count()),
+			"max":      IntValue(ms.Max()),
+			"mean":     FloatValue(ms.Mean()),
+			"min":      IntValue(ms.Min()),
+			"stddev":   FloatValue(ms.StdDev()),
+			"variance": FloatValue(ms.Variance()),
+		}
+		c.percentiles(fields, ms.Percentiles)
+		return c.metric(name, TypeHistogram, now, fields)
+	case metrics.Sample:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"count":    IntValue(ms.Count()),
+			"max":      IntValue(ms.Max()),
+			"mean":     FloatValue(ms.Mean()),
+			"min":      IntValue(ms.Min()),
+			"stddev":   FloatValue(ms.StdDev()),
+			"variance": FloatValue(ms.Variance()),
+		}
+		c.percentiles(fields, ms.Percentiles)
+		return c.metric(name, TypeHistogram, now, fields)
+	case metrics.Meter:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"count": IntValue(ms.Count()),
+			"m1":    FloatValue(ms.Rate1()),
+			"m5":    FloatValue(ms.Rate5()),
+			"m15":   FloatValue(ms.Rate15()),
+			"mean":  FloatValue(ms.RateMean()),
+		}
+		return c.metric(name, TypeMeter, now, fields)
+	case metrics.Timer:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"count":    IntValue(ms.Count()),
+			"max":      IntValue(ms.Max()),
+			"mean":     FloatValue(ms.Mean()),
+			"min":      IntValue(ms.Min()),
+			"stddev":   FloatValue(ms.StdDev()),
+			"variance": FloatValue(ms.Variance()),
+			"m1":       FloatValue(ms.Rate1()),
+			"m5":       FloatValue(ms.Rate5()),
+			"m15":      FloatValue(ms.Rate15()),
+			"meanrate": FloatValue(ms.RateMean()),
+		}
+		c.percentiles(fields, ms.Percentiles)
+		return c.metric(name, TypeTimer, now, fields)
+	case metrics.Gauge:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"gauge": IntValue(ms.Value()),
+		}
+		return c.metric(name, TypeGauge, now, fields)
+	case metrics.GaugeFloat64:
+		ms := metric.Snapshot()
+		fields := map[string]Value{
+			"gauge": FloatValue(ms.Value()),
+		}
+		return c.metric(name, TypeGauge, now, fields)
+	case metrics.EWMA:
+		fields := map[string]Value{
+			"rate": FloatValue(metric.Snapshot().Rate()),
+		}
+		return c.metric(name, TypeEWMA, now, fields)
+	case metrics.Healthcheck:
+		if c.RunHealthchecks {
+			metric.Check()
+		}
+		err := metric.Error()
+		fields := map[string]Value{
+			"healthy": BoolValue(err == nil),
+		}
+		m := c.metric(name, TypeHealthcheck, now, fields)
+		if err != nil {
+			m.Labels = map[string]string{"error": err.Error()}
+		}
+		return m
+	}
+	return nil
+}
+
+func (c *Collector) percentiles(fields map[string]Value, percentiles func([]float64) []float64) {
+	if len(c.Percentiles) == 0 {
+		return
+	}
+	nameOf := c.PercentileName
+	if nameOf == nil {
+		nameOf = PercentileP
+	}
+	ps := percentiles(c.Percentiles)
+	for i, q := range c.Percentiles {
+		fields[nameOf(q)] = FloatValue(ps[i])
+	}
+}
+
+func (c *Collector) metric(name string, typ MetricType, now time.Time, fields map[string]Value) *Metric {
+	return c.filter(&Metric{Name: name, Type: typ, Time: now, Fields: fields})
+}
+
+// Keep fields included and not excluded.
+func (c *Collector) filter(metric *Metric) *Metric {
+	if include := c.Include[metric.Type]; len(include) > 0 {
+		included := make(map[string]Value, len(include))
+		for _, f := range include {
+			if v, ok := metric.Fields[f]; ok {
+				included[f] = v
+			}
+		}
+		metric.Fields = included
+	}
+	for _, f := range c.Exclude[metric.Type] {
+		delete(metric.Fields, f)
+	}
+	return metric
+}
+
+// Name percentile field by digits of quantile, e.g. 0.5 => p50, 0.99 => p99,
+// 0.999 => p999, and 1 => p100.
+func PercentileP(q float64) string {
+	if q >= 1 {
+		return "p100"
+	}
+	digits := strings.TrimPrefix(strconv.FormatFloat(q, 'f', -1, 64), "0.")
+	if len(digits) == 1 {
+		digits += "0"
+	}
+	return "p" + digits
+}
+
+// Name percentile field by quantile, e.g. 0.99 => quantile=0.99.
+func PercentileQuantile(q float64) string {
+	return "quantile=" + strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+// Whether timer field measures duration in nanoseconds, i.e. min, max, mean,
+// stddev and percentiles, as opposed to count and rates.
+func IsTimerDuration(field string) bool {
+	switch field {
+	case "min", "max", "mean", "stddev":
+		return true
+	}
+	_, ok := ParsePercentile(field)
+	return ok
+}
+
+// Parse quantile from percentile field named by PercentileP or
+// PercentileQuantile, e.g. p99 => 0.99, quantile=0.99 => 0.99.
+func ParsePercentile(field string) (float64, bool) {
+	if s, ok := trimPrefix(field, "quantile="); ok {
+		q, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(q >= 0 && q <= 1) {
+			return 0, false
+		}
+		return q, true
+	}
+	digits, ok := trimPrefix(field, "p")
+	if !ok || digits == "" {
+		return 0, false
+	}
+	if _, err := strconv.ParseUint(digits, 10, 64); err != nil {
+		return 0, false
+	}
+	if digits == "100" {
+		return 1, true
+	}
+	q, err := strconv.ParseFloat("0."+digits, 64)
+	if err != nil {
+		return 0, false
+	}
+	return q, true
+}
+
+func trimPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,144 @@
+package exporters
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	assert := assert.New(t)
+	timer := metrics.NewTimer()
+	for i := 1; i <= 100; i++ {
+		timer.Update(time.Duration(i))
+	}
+
+	metric := CollectMetric("latency", timer)
+	assert.Len(metric.Fields, 16)
+	assert.Equal(FloatValue(50.5), metric.Fields["p50"])
+	assert.Equal(IntValue(100), metric.Fields["count"])
+
+	c := &Collector{
+		Percentiles:    []float64{0.5, 0.99},
+		PercentileName: PercentileQuantile,
+		Exclude: map[MetricType][]string{
+			TypeTimer: {"stddev", "variance", "m1", "m5", "m15", "meanrate"},
+		},
+	}
+	metric = c.Collect("latency", timer)
+	assert.Equal(map[string]Value{
+		"count": IntValue(100), "max": IntValue(100), "mean": FloatValue(50.5), "min": IntValue(1),
+		"quantile=0.5": FloatValue(50.5), "quantile=0.99": FloatValue(99.99),
+	}, metric.Fields)
+
+	c = &Collector{
+		Percentiles: []float64{0.99},
+		Include: map[MetricType][]string{
+			TypeTimer: {"count", "p99"},
+		},
+	}
+	metric = c.Collect("latency", timer)
+	assert.Equal(map[string]Value{"count": IntValue(100), "p99": FloatValue(99.99)}, metric.Fields)
+	counter := metrics.NewCounter()
+	counter.Inc(3)
+	assert.Equal(map[string]Value{"count": IntValue(3)}, c.Collect("req", counter).Fields, "Other types unaffected")
+}
+
+func TestPercentileName(t *testing.T) {
+	assert := assert.New(t)
+	for _, q := range []float64{0, 0.5, 0.75, 0.95, 0.99, 0.999, 0.9999, 1} {
+		for _, nameOf := range []func(float64) string{PercentileP, PercentileQuantile} {
+			p, ok := ParsePercentile(nameOf(q))
+			assert.True(ok, nameOf(q))
+			assert.Equal(q, p, nameOf(q))
+		}
+	}
+	assert.Equal("p50", PercentileP(0.5))
+	assert.Equal("p999", PercentileP(0.999))
+	assert.Equal("quantile=0.99", PercentileQuantile(0.99))
+	for _, f := range []string{"p", "pxx", "mean", "quantile=1.5", "quantile="} {
+		_, ok := ParsePercentile(f)
+		assert.False(ok, f)
+	}
+}
+
+func TestIsTimerDuration(t *testing.T) {
+	for _, f := range []string{"min", "max", "mean", "stddev", "p99", "quantile=0.5"} {
+		assert.True(t, IsTimerDuration(f), f)
+	}
+	for _, f := range []string{"count", "m1", "mean-rate", "sum"} {
+		assert.False(t, IsTimerDuration(f), f)
+	}
+}
+
+func TestCollectOtherTypes(t *testing.T) {
+	assert := assert.New(t)
+	c := &Collector{RunHealthchecks: true}
+
+	healthy := true
+	check := metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		if healthy {
+			h.Healthy()
+		} else {
+			h.Unhealthy(errors.New("db down"))
+		}
+	})
+	metric := c.Collect("db", check)
+	assert.Equal(TypeHealthcheck, metric.Type)
+	assert.Equal(map[string]Value{"healthy": BoolValue(true)}, metric.Fields)
+	assert.Empty(metric.Labels)
+	healthy = false
+	metric = c.Collect("db", check)
+	assert.Equal(map[string]Value{"healthy": BoolValue(false)}, metric.Fields)
+	assert.Equal(map[string]string{"error": "db down"}, metric.Labels)
+
+	ewma := metrics.NewEWMA1()
+	ewma.Update(60)
+	ewma.Tick()
+	metric = c.Collect("load", ewma)
+	assert.Equal(TypeEWMA, metric.Type)
+	assert.Equal(map[string]Value{"rate": FloatValue(12)}, metric.Fields)
+
+	sample := metrics.NewUniformSample(10)
+	sample.Update(2)
+	sample.Update(4)
+	metric = c.Collect("size", sample)
+	assert.Equal(TypeHistogram, metric.Type)
+	assert.Equal(FloatValue(3), metric.Fields["mean"])
+
+	gauge := metrics.NewFunctionalGauge(func() int64 { return 42 })
+	assert.Equal(map[string]Value{"gauge": IntValue(42)}, c.Collect("fn", gauge).Fields)
+
+	assert.Nil(c.Collect("unknown", struct{}{}))
+}
+
+type versionInfo struct{ version string }
+
+func TestCollectFunc(t *testing.T) {
+	assert := assert.New(t)
+	c := &Collector{
+		Exclude: map[MetricType][]string{"info": {"ignored"}},
+	}
+	c.Register(func(name string, metric any) *Metric {
+		info, ok := metric.(*versionInfo)
+		if !ok {
+			return nil
+		}
+		return &Metric{
+			Name:   name,
+			Type:   "info",
+			Labels: map[string]string{"version": info.version},
+			Fields: map[string]Value{"value": IntValue(1), "ignored": IntValue(0)},
+		}
+	})
+	metric := c.Collect("build", &versionInfo{"1.2.0"})
+	assert.Equal(MetricType("info"), metric.Type)
+	assert.Equal(map[string]string{"version": "1.2.0"}, metric.Labels)
+	assert.Equal(map[string]Value{"value": IntValue(1)}, metric.Fields)
+
+	counter := metrics.NewCounter()
+	assert.Equal(TypeCounter, c.Collect("req", counter).Type, "Fallback to builtin types")
+}
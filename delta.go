@@ -0,0 +1,122 @@
+package exporters
+
+// A delta tracker remembers count of each series in last poll, to convert
+// cumulative counts to deltas.
+type deltaTracker struct {
+	prev map[string]Value // series key => count of last poll
+	curr map[string]Value // series key => count of current poll
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		prev: make(map[string]Value),
+		curr: make(map[string]Value),
+	}
+}
+
+// Convert count field of counter, meter, timer and histogram to delta since
+// last poll. If count decreases, counter is regarded as reset, and the whole
+// count is taken as delta. Series first seen is regarded as starting from 0.
+func (t *deltaTracker) apply(metric *Metric) {
+	count, ok := deltaCount(metric)
+	if !ok {
+		return
+	}
+	key := metric.SeriesKey()
+	t.curr[key] = count
+	if prev, ok := t.prev[key]; ok && prev.Kind() == count.Kind() {
+		metric.Fields["count"] = subtract(count, prev)
+	}
+}
+
+// Count field of counter, meter, timer and histogram, which is converted to
+// delta in delta mode.
+func deltaCount(metric *Metric) (Value, bool) {
+	switch metric.Type {
+	case TypeCounter, TypeMeter, TypeTimer, TypeHistogram:
+		count, ok := metric.Fields["count"]
+		return count, ok
+	}
+	return Value{}, false
+}
+
+// Subtract prev from count of same kind, or count itself if it decreases.
+func subtract(count, prev Value) Value {
+	switch count.Kind() {
+	case KindInt:
+		if count.Int() >= prev.Int() {
+			return IntValue(count.Int() - prev.Int())
+		}
+	case KindUint:
+		if count.Uint() >= prev.Uint() {
+			return UintValue(count.Uint() - prev.Uint())
+		}
+	case KindFloat:
+		if count.Float() >= prev.Float() {
+			return FloatValue(count.Float() - prev.Float())
+		}
+	}
+	return count
+}
+
+// Commit current poll, series not seen in current poll are forgotten.
+func (t *deltaTracker) commit() {
+	t.prev, t.curr = t.curr, t.prev
+	for k := range t.curr {
+		delete(t.curr, k)
+	}
+}
+
+// Deltas of polls skipped by one emitter, which are added to the next poll it
+// emits, see EmitterInterval.
+type skippedDeltas map[string]Value // series key => sum of deltas
+
+// Sum up deltas of skipped poll.
+func (s skippedDeltas) skip(metrics []*Metric) {
+	for _, metric := range metrics {
+		count, ok := deltaCount(metric)
+		if !ok {
+			continue
+		}
+		key := metric.SeriesKey()
+		if sum, ok := s[key]; ok {
+			count = add(sum, count)
+		}
+		s[key] = count
+	}
+}
+
+// Add deltas of skipped polls to copies of metrics, then forget them, where
+// series not seen in metrics are forgotten as well.
+func (s skippedDeltas) addTo(metrics []*Metric) []*Metric {
+	if len(s) == 0 {
+		return metrics
+	}
+	points := make([]*Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if count, ok := deltaCount(metric); ok {
+			if sum, ok := s[metric.SeriesKey()]; ok {
+				metric = metric.Clone()
+				metric.Fields["count"] = add(sum, count)
+			}
+		}
+		points = append(points, metric)
+	}
+	for k := range s {
+		delete(s, k)
+	}
+	return points
+}
+
+// Add counts of same kind, or as float if kinds differ.
+func add(a, b Value) Value {
+	if a.Kind() == b.Kind() {
+		switch a.Kind() {
+		case KindInt:
+			return IntValue(a.Int() + b.Int())
+		case KindUint:
+			return UintValue(a.Uint() + b.Uint())
+		}
+	}
+	return FloatValue(a.Float() + b.Float())
+}
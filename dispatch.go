@@ -0,0 +1,185 @@
+package exporters
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Policy to apply when an emitter falls behind, and its queue is full.
+type QueuePolicy int
+
+const (
+	// Drop oldest batch in queue to make room for the new one.
+	DropOldest QueuePolicy = iota
+	// Block reporting until the emitter catches up, which delays all emitters.
+	Block
+)
+
+// A dispatcher emits batches to one emitter on its own goroutine, so that a
+// slow emitter can not stall the others.
+type dispatcher struct {
+	emitter Emitter
+	queue   chan []*Metric
+	policy  QueuePolicy
+	timeout time.Duration // per emit deadline, 0 means no deadline
+	logf    func(format string, a ...any)
+	onError func(err *EmitError) // optional
+	self    *emitterMetrics
+	sent    int64 // last bytes sent by emitter, see ByteCounter
+
+	ctx    context.Context // base context of each emit, cancelled on shutdown
+	cancel context.CancelFunc
+	guard  EmitGuard     // abandons emit after deadline
+	done   chan struct{} // closed when queue is drained
+
+	inflight  int32 // batches enqueued but not yet emitted
+	abandoned int32 // set once an emit is abandoned after deadline
+}
+
+func newDispatcher(em Emitter, size int, policy QueuePolicy, timeout time.Duration,
+	logf func(format string, a ...any), onError func(err *EmitError), self *emitterMetrics) *dispatcher {
+	if size < 1 {
+		size = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dispatcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		emitter: em,
+		queue:   make(chan []*Metric, size),
+		policy:  policy,
+		timeout: timeout,
+		logf:    logf,
+		onError: onError,
+		self:    self,
+		done:    make(chan struct{}),
+	}
+}
+
+func (d *dispatcher) start() {
+	go d.loop()
+}
+
+// Enqueue batch to be emitted, it returns false if ctx is done before batch
+// is enqueued. It must not be called concurrently, nor after close.
+func (d *dispatcher) enqueue(ctx context.Context, batch []*Metric) bool {
+	atomic.AddInt32(&d.inflight, 1)
+	if d.policy == Block {
+		select {
+		case d.queue <- batch:
+			return true
+		case <-ctx.Done():
+			atomic.AddInt32(&d.inflight, -1)
+			return false
+		}
+	}
+	for {
+		select {
+		case d.queue <- batch:
+			return true
+		default:
+		}
+		select {
+		case old := <-d.queue:
+			atomic.AddInt32(&d.inflight, -1)
+			d.fail(&EmitError{Emitter: d.emitter.Name(), Op: OpEmit, Points: len(old), Err: ErrQueueFull})
+		default:
+		}
+	}
+}
+
+// Close queue, and return whether dispatcher is idle, i.e. queued batches are
+// all emitted, in which case it waits for loop to exit, which is prompt. No
+// batch should be enqueued after.
+func (d *dispatcher) closeQueue() bool {
+	close(d.queue)
+	if atomic.LoadInt32(&d.inflight) > 0 || atomic.LoadInt32(&d.abandoned) > 0 {
+		return false
+	}
+	<-d.done
+	d.cancel()
+	return true
+}
+
+// Wait until queued batches are emitted after closeQueue, or ctx is done, in
+// which case in-flight emit is cancelled and queued batches are dropped.
+func (d *dispatcher) wait(ctx context.Context) error {
+	defer d.cancel()
+	select {
+	case <-d.done:
+		return nil
+	default:
+	}
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close queue and wait until queued batches are emitted, see wait.
+func (d *dispatcher) close(ctx context.Context) error {
+	if d.closeQueue() {
+		return nil
+	}
+	return d.wait(ctx)
+}
+
+func (d *dispatcher) loop() {
+	defer close(d.done)
+	for batch := range d.queue {
+		if d.ctx.Err() == nil {
+			d.emit(batch)
+		}
+		if d.guard.pending != nil {
+			atomic.StoreInt32(&d.abandoned, 1)
+		}
+		atomic.AddInt32(&d.inflight, -1)
+	}
+	d.guard.Wait(context.Background())
+}
+
+func (d *dispatcher) emit(batch []*Metric) {
+	start := time.Now()
+	err := d.emitContext(batch)
+	d.self.recordEmit(start, d.bytesSent(), err)
+	if err != nil {
+		d.fail(&EmitError{Emitter: d.emitter.Name(), Op: OpEmit, Points: len(batch), Err: err})
+	} else {
+		d.logf("Reported %d metric points to %s\n", len(batch), d.emitter.Name())
+	}
+}
+
+// Bytes sent since last call, 0 if emitter is not a ByteCounter.
+func (d *dispatcher) bytesSent() int64 {
+	bc, ok := d.emitter.(ByteCounter)
+	if !ok {
+		return 0
+	}
+	sent := bc.BytesSent()
+	delta := sent - d.sent
+	d.sent = sent
+	return delta
+}
+
+// Log and handle failed report.
+func (d *dispatcher) fail(err *EmitError) {
+	d.self.failure.Inc(1)
+	d.logf("ERROR: %s\n", err.Error())
+	if d.onError != nil {
+		d.onError(err)
+	}
+}
+
+// Emit batch under deadline, see EmitGuard.
+func (d *dispatcher) emitContext(batch []*Metric) error {
+	ctx := d.ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	return d.guard.Emit(ctx, d.emitter, batch...)
+}
@@ -0,0 +1,118 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// A fake emitter records batches, and blocks each emit for delay or until
+// gate is closed.
+type fakeEmitter struct {
+	name  string
+	delay time.Duration
+	gate  chan struct{}
+
+	mu      sync.Mutex
+	batches [][]*Metric
+	closed  bool
+}
+
+func (em *fakeEmitter) Name() string { return em.name }
+
+func (em *fakeEmitter) Close() error {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.closed = true
+	return nil
+}
+
+func (em *fakeEmitter) isClosed() bool {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	return em.closed
+}
+
+func (em *fakeEmitter) Emit(metrics ...*Metric) error {
+	if em.gate != nil {
+		<-em.gate
+	}
+	time.Sleep(em.delay)
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.batches = append(em.batches, metrics)
+	return nil
+}
+
+func (em *fakeEmitter) count() int {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	return len(em.batches)
+}
+
+func TestSlowEmitterNotStallOthers(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	slow := &fakeEmitter{name: "slow", delay: 300 * time.Millisecond}
+	fast := &fakeEmitter{name: "fast"}
+	var logs []string
+	var mu sync.Mutex
+	rep, err := NewReporter(reg, 50*time.Millisecond).
+		WithEmitter(slow).
+		WithEmitter(fast).
+		WithQueue(1, DropOldest).
+		WithLogger(func(format string, a ...any) {
+			mu.Lock()
+			defer mu.Unlock()
+			logs = append(logs, fmt.Sprintf(format, a...))
+		}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	time.Sleep(280 * time.Millisecond)
+	assert.GreaterOrEqual(fast.count(), 4)
+	assert.LessOrEqual(slow.count(), 1)
+	mu.Lock()
+	assert.Contains(logs, "ERROR: Report 1 metric points to slow error: context deadline exceeded\n")
+	mu.Unlock()
+	rep.Close()
+}
+
+func TestDispatcherDropOldest(t *testing.T) {
+	assert := assert.New(t)
+	em := &fakeEmitter{name: "blocked", gate: make(chan struct{})}
+	d := newDispatcher(em, 1, DropOldest, 0, func(string, ...any) {}, nil, newSelfMetrics().emitter("test"))
+	d.start()
+	batches := make([][]*Metric, 4)
+	for i := range batches {
+		batches[i] = []*Metric{{Name: fmt.Sprintf("m%d", i)}}
+	}
+	d.enqueue(context.Background(), batches[0])
+	// wait until first batch is in flight
+	time.Sleep(20 * time.Millisecond)
+	d.enqueue(context.Background(), batches[1])
+	d.enqueue(context.Background(), batches[2])
+	d.enqueue(context.Background(), batches[3])
+	close(em.gate)
+	d.close(context.Background())
+	assert.Equal([][]*Metric{batches[0], batches[3]}, em.batches)
+}
+
+func TestDispatcherBlock(t *testing.T) {
+	assert := assert.New(t)
+	em := &fakeEmitter{name: "slow", delay: 10 * time.Millisecond}
+	d := newDispatcher(em, 1, Block, 0, func(string, ...any) {}, nil, newSelfMetrics().emitter("test"))
+	d.start()
+	for i := 0; i < 4; i++ {
+		d.enqueue(context.Background(), []*Metric{{Name: "m"}})
+	}
+	d.close(context.Background())
+	assert.Equal(4, em.count())
+}
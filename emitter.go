@@ -1,5 +1,7 @@
 package exporters
 
+import "context"
+
 // An emitter receive metric points from reporter, and publish them to database.
 type Emitter interface {
 	// Transform metric points and publish to database
@@ -10,3 +12,115 @@ type Emitter interface {
 
 	Close() error
 }
+
+// An emitter may also implement ResourceEmitter to be notified of reporter
+// global labels when started, e.g. to encode them as resource attributes once
+// per batch. Global labels are still attached to each metric.
+type ResourceEmitter interface {
+	Emitter
+
+	// Set labels that describe the reporting resource, such as host.
+	SetResource(labels map[string]string)
+}
+
+// An emitter may also implement DeltaEmitter to be notified whether reporter
+// reports counts as deltas since last poll, see Reporter.WithDelta, e.g. to
+// encode them with delta temporality.
+type DeltaEmitter interface {
+	Emitter
+
+	// Set whether counts are deltas, or cumulative. It returns error if the
+	// mode is not supported, which fails reporter to start.
+	SetDelta(delta bool) error
+}
+
+// An emitter may also implement ContextEmitter to honor emit deadline, which
+// is configured by Reporter.WithEmitTimeout. Otherwise emit can not be
+// cancelled, though reporter does not wait for it beyond deadline.
+type ContextEmitter interface {
+	Emitter
+
+	// Same as Emit, but should return once ctx is done.
+	EmitContext(ctx context.Context, metrics ...*Metric) error
+}
+
+// An emitter may also implement ByteCounter to report bytes it has sent, which
+// is recorded in reporter self metrics, see Reporter.SelfRegistry.
+type ByteCounter interface {
+	// Total bytes sent since created.
+	BytesSent() int64
+}
+
+// An EmitterWrapper forwards Emitter and optional interfaces, i.e.
+// ByteCounter, ResourceEmitter and DeltaEmitter, to the wrapped emitter. Embed
+// it in emitters wrapping another one, and override what differs, e.g. Emit.
+type EmitterWrapper struct {
+	Emitter
+}
+
+// Bytes sent by wrapped emitter, 0 if it is not a ByteCounter.
+func (w EmitterWrapper) BytesSent() int64 {
+	if bc, ok := w.Emitter.(ByteCounter); ok {
+		return bc.BytesSent()
+	}
+	return 0
+}
+
+// Set resource of wrapped emitter, if it is a ResourceEmitter.
+func (w EmitterWrapper) SetResource(labels map[string]string) {
+	if em, ok := w.Emitter.(ResourceEmitter); ok {
+		em.SetResource(labels)
+	}
+}
+
+// Set delta mode of wrapped emitter, if it is a DeltaEmitter.
+func (w EmitterWrapper) SetDelta(delta bool) error {
+	if em, ok := w.Emitter.(DeltaEmitter); ok {
+		return em.SetDelta(delta)
+	}
+	return nil
+}
+
+// An EmitGuard emits to an emitter under ctx, even if it is not a
+// ContextEmitter, e.g. for emitters wrapping another one. Emit of such emitter
+// is abandoned once ctx is done, while next emit waits for it, so that emits
+// never overlap. Zero value is ready to use, but it must not be used
+// concurrently.
+type EmitGuard struct {
+	pending chan error // result of last emit abandoned
+}
+
+// Emit metrics to em, and return once it is done or ctx is done.
+func (g *EmitGuard) Emit(ctx context.Context, em Emitter, metrics ...*Metric) error {
+	if err := g.Wait(ctx); err != nil {
+		return err
+	}
+	if em, ok := em.(ContextEmitter); ok {
+		return em.EmitContext(ctx, metrics...)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- em.Emit(metrics...)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		g.pending = errc
+		return ctx.Err()
+	}
+}
+
+// Wait until last abandoned emit returns, or ctx is done.
+func (g *EmitGuard) Wait(ctx context.Context) error {
+	if g.pending == nil {
+		return nil
+	}
+	select {
+	case <-g.pending:
+		g.pending = nil
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,282 @@
+package emitters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiYellow = "\x1b[1;33m"
+	ansiCyan   = "\x1b[1;36m"
+)
+
+// Key fields shown of each type by default, other types show all fields.
+var defaultConsoleFields = map[exporters.MetricType][]string{
+	exporters.TypeCounter:     {"count"},
+	exporters.TypeGauge:       {"gauge"},
+	exporters.TypeMeter:       {"count", "m1", "m5", "m15"},
+	exporters.TypeTimer:       {"count", "mean", "p50", "p99", "max", "m1"},
+	exporters.TypeHistogram:   {"count", "mean", "p50", "p99", "max"},
+	exporters.TypeEWMA:        {"rate"},
+	exporters.TypeHealthcheck: {"healthy"},
+}
+
+// Order of type tables, other types follow in alpha-num order.
+var consoleTypeOrder = []exporters.MetricType{
+	exporters.TypeCounter,
+	exporters.TypeGauge,
+	exporters.TypeMeter,
+	exporters.TypeTimer,
+	exporters.TypeHistogram,
+	exporters.TypeEWMA,
+	exporters.TypeHealthcheck,
+}
+
+// Emit metrics to console as human-readable tables, one per metric type, for
+// local development, e.g.
+//
+//    --- 2022-10-30 09:49:17 (2 metrics) ---
+//    counter
+//    NAME  LABELS      COUNT
+//    req   host=node1  1027
+//
+//    timer
+//    NAME         LABELS  COUNT  MEAN     P50    P99      MAX      M1
+//    req.latency          1027   1.235ms  1.1ms  4.302ms  10.05ms  17.2
+//
+// Duration fields of timers are formatted as durations. Values changed since
+// last poll are highlighted, in color if enabled, otherwise marked with `*`.
+func NewConsoleEmitter(writer io.Writer, opts ...ConsoleOption) *consoleEmitter {
+	em := &consoleEmitter{
+		writer: writer,
+		fields: make(map[exporters.MetricType][]string, len(defaultConsoleFields)),
+		last:   make(map[string]exporters.Value),
+	}
+	for typ, fields := range defaultConsoleFields {
+		em.fields[typ] = fields
+	}
+	for _, opt := range opts {
+		opt(em)
+	}
+	return em
+}
+
+// Emit metrics to stdout as tables, see NewConsoleEmitter.
+func NewStdoutConsoleEmitter(opts ...ConsoleOption) *consoleEmitter {
+	return NewConsoleEmitter(os.Stdout, opts...)
+}
+
+type consoleEmitter struct {
+	writer    io.Writer
+	color     bool                              // colorize with ansi escape codes
+	highlight bool                              // highlight changed values
+	fields    map[exporters.MetricType][]string // key fields shown per type
+
+	mu   sync.Mutex
+	last map[string]exporters.Value // series key and field => value of last poll
+}
+
+func (this *consoleEmitter) Name() string {
+	return "console"
+}
+
+func (this *consoleEmitter) Close() error {
+	return nil
+}
+
+func (this *consoleEmitter) Emit(metrics ...*exporters.Metric) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	groups := make(map[exporters.MetricType][]*exporters.Metric)
+	for _, metric := range metrics {
+		groups[metric.Type] = append(groups[metric.Type], metric)
+	}
+	bw := bufio.NewWriter(this.writer)
+	fmt.Fprintf(bw, "--- %s (%d metrics) ---\n", time.Now().Format("2006-01-02 15:04:05"), len(metrics))
+	last := make(map[string]exporters.Value, len(this.last))
+	for i, typ := range this.typeOrder(groups) {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		this.writeTable(bw, typ, groups[typ], last)
+	}
+	this.last = last
+	return bw.Flush()
+}
+
+// Types present in groups, builtin types first.
+func (this *consoleEmitter) typeOrder(groups map[exporters.MetricType][]*exporters.Metric) []exporters.MetricType {
+	var types, others []exporters.MetricType
+	for _, typ := range consoleTypeOrder {
+		if _, ok := groups[typ]; ok {
+			types = append(types, typ)
+		}
+	}
+	for typ := range groups {
+		if _, ok := defaultConsoleFields[typ]; !ok {
+			others = append(others, typ)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i] < others[j]
+	})
+	return append(types, others...)
+}
+
+type consoleCell struct {
+	text    string
+	changed bool
+}
+
+func (this *consoleEmitter) writeTable(w *bufio.Writer, typ exporters.MetricType, metrics []*exporters.Metric, last map[string]exporters.Value) {
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].SeriesKey() < metrics[j].SeriesKey()
+	})
+	fields := this.fields[typ]
+	if len(fields) == 0 {
+		fields = allFields(metrics)
+	}
+	header := []consoleCell{{text: "NAME"}, {text: "LABELS"}}
+	for _, f := range fields {
+		header = append(header, consoleCell{text: strings.ToUpper(f)})
+	}
+	rows := [][]consoleCell{header}
+	for _, metric := range metrics {
+		key := metric.SeriesKey()
+		row := []consoleCell{{text: metric.Name}, {text: formatLabels(metric.Labels)}}
+		for _, f := range fields {
+			v, ok := metric.Fields[f]
+			if !ok {
+				row = append(row, consoleCell{text: "-"})
+				continue
+			}
+			prev, seen := this.last[key+"/"+f]
+			last[key+"/"+f] = v
+			cell := consoleCell{text: formatField(typ, f, v), changed: seen && prev != v && this.highlight}
+			if cell.changed && !this.color {
+				cell.text += "*"
+			}
+			row = append(row, cell)
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := len([]rune(cell.text)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if this.color {
+		w.WriteString(ansiCyan + string(typ) + ansiReset + "\n")
+	} else {
+		w.WriteString(string(typ) + "\n")
+	}
+	for r, row := range rows {
+		for i, cell := range row {
+			text := cell.text
+			pad := widths[i] - len([]rune(text))
+			switch {
+			case r == 0 && this.color:
+				text = ansiBold + text + ansiReset
+			case cell.changed && this.color:
+				text = ansiYellow + text + ansiReset
+			}
+			w.WriteString(text)
+			if i < len(row)-1 {
+				w.WriteString(strings.Repeat(" ", pad+2))
+			}
+		}
+		w.WriteString("\n")
+	}
+}
+
+// Union of fields in metrics, sorted.
+func allFields(metrics []*exporters.Metric) []string {
+	set := make(map[string]bool)
+	for _, metric := range metrics {
+		for f := range metric.Fields {
+			set[f] = true
+		}
+	}
+	fields := make([]string, 0, len(set))
+	for f := range set {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, entry := range exporters.SortByKey(labels) {
+		pairs = append(pairs, entry.Key+"="+entry.Val)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Format duration fields of timer as durations, and floats with at most 2
+// decimals.
+func formatField(typ exporters.MetricType, field string, v exporters.Value) string {
+	if typ == exporters.TypeTimer && v.IsNumeric() && exporters.IsTimerDuration(field) {
+		return formatDuration(time.Duration(v.Float()))
+	}
+	if v.Kind() != exporters.KindFloat {
+		return v.String()
+	}
+	f := v.Float()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return v.String()
+	}
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// Round duration to about 4 significant digits, e.g. 1.235ms.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		d = d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		d = d.Round(time.Microsecond)
+	}
+	return d.String()
+}
+
+type ConsoleOption func(*consoleEmitter)
+
+// Colorize tables with ansi escape codes (or not), default to no color.
+func WithColor(b bool) ConsoleOption {
+	return func(em *consoleEmitter) {
+		em.color = b
+	}
+}
+
+// Highlight values changed since last poll (or not), default to no highlight.
+func WithHighlight(b bool) ConsoleOption {
+	return func(em *consoleEmitter) {
+		em.highlight = b
+	}
+}
+
+// Key fields shown of metric type, replacing default ones, or all fields if
+// none given.
+func WithConsoleFields(typ exporters.MetricType, fields ...string) ConsoleOption {
+	return func(em *consoleEmitter) {
+		em.fields[typ] = fields
+	}
+}
@@ -0,0 +1,75 @@
+package emitters
+
+import (
+	"strings"
+	"testing"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleEmitter(t *testing.T) {
+	assert := assert.New(t)
+	var buf strings.Builder
+	em := NewConsoleEmitter(&buf, WithHighlight(true))
+	timer := &exporters.Metric{Name: "req.latency", Type: exporters.TypeTimer,
+		Fields: map[string]exporters.Value{
+			"count": exporters.IntValue(1027),
+			"mean":  exporters.FloatValue(1234567),
+			"p50":   exporters.FloatValue(1100000),
+			"p99":   exporters.FloatValue(4302100),
+			"max":   exporters.IntValue(10050000),
+			"m1":    exporters.FloatValue(17.2),
+		}}
+	counter := &exporters.Metric{Name: "req", Type: exporters.TypeCounter,
+		Labels: map[string]string{"host": "node1"},
+		Fields: map[string]exporters.Value{"count": exporters.IntValue(5)}}
+	custom := &exporters.Metric{Name: "queue", Type: "custom",
+		Fields: map[string]exporters.Value{"size": exporters.IntValue(3), "ok": exporters.BoolValue(true)}}
+	if err := em.Emit(timer, counter, custom); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	out := buf.String()
+	assert.True(strings.HasPrefix(out, "--- "))
+	assert.Equal(`(3 metrics) ---
+counter
+NAME  LABELS      COUNT
+req   host=node1  5
+
+timer
+NAME         LABELS  COUNT  MEAN     P50    P99      MAX      M1
+req.latency          1027   1.235ms  1.1ms  4.302ms  10.05ms  17.2
+
+custom
+NAME   LABELS  OK    SIZE
+queue          true  3
+`, out[strings.Index(out, "(3 metrics)"):])
+
+	buf.Reset()
+	counter.Fields["count"] = exporters.IntValue(12)
+	if err := em.Emit(counter); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	out = buf.String()
+	assert.Equal(`(1 metrics) ---
+counter
+NAME  LABELS      COUNT
+req   host=node1  12*
+`, out[strings.Index(out, "(1 metrics)"):])
+}
+
+func TestConsoleEmitterColor(t *testing.T) {
+	assert := assert.New(t)
+	var buf strings.Builder
+	em := NewConsoleEmitter(&buf, WithColor(true), WithHighlight(true), WithConsoleFields(exporters.TypeGauge, "gauge", "min"))
+	gauge := &exporters.Metric{Name: "temp", Type: exporters.TypeGauge,
+		Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(36.5)}}
+	em.Emit(gauge)
+	gauge.Fields["gauge"] = exporters.FloatValue(37.25)
+	buf.Reset()
+	em.Emit(gauge)
+	out := buf.String()
+	assert.Contains(out, ansiCyan+"gauge"+ansiReset+"\n")
+	assert.Contains(out, ansiBold+"GAUGE"+ansiReset)
+	assert.Contains(out, "temp  "+strings.Repeat(" ", len("LABELS")+2)+ansiYellow+"37.25"+ansiReset+"  -\n")
+}
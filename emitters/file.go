@@ -1,10 +1,17 @@
 package emitters
 
 import (
-	"encoding/json"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	exporters "github.com/juvenn/metric-exporters"
@@ -16,53 +23,146 @@ func NewIOReporter(writer io.Writer, reg metrics.Registry, pollInterval time.Dur
 	return exporters.NewReporter(reg, pollInterval).WithEmitter(NewIOEmitter(writer))
 }
 
-// Emit metrics to file
-func NewFileEmitter(path string) (*fileEmitter, error) {
-	file, err := os.Open(path)
-	if err != nil {
+// Emit metrics to file, which is created if not exists, or appended to. Format
+// default to json lines, and file is not rotated by default, e.g. to rotate
+// daily and keep backups of a week:
+//
+//    em, err := emitters.NewFileEmitter("/var/log/metrics.log",
+//        emitters.WithFormat("influx"),
+//        emitters.WithRotateInterval(24*time.Hour),
+//        emitters.WithMaxBackups(7),
+//        emitters.WithGzip(true))
+//
+// Rotated files are renamed with timestamp suffix, e.g.
+// metrics.log.20221030-094917.000000000, or with .gz suffix if compressed.
+func NewFileEmitter(path string, opts ...FileOption) (*fileEmitter, error) {
+	em := &fileEmitter{
+		name:   "file: " + path,
+		path:   path,
+		format: "json",
+	}
+	for _, opt := range opts {
+		opt(em)
+	}
+	if _, err := newStreamEncoder(em.format); err != nil {
 		return nil, err
 	}
-	return NewIOEmitter(file), nil
+	if err := em.open(); err != nil {
+		return nil, err
+	}
+	return em, nil
 }
 
-// Emit metrics to io writer
-func NewIOEmitter(writer io.Writer) *fileEmitter {
-	return &fileEmitter{
+// Emit metrics to io writer, as json lines by default, or in format given by
+// WithFormat, while rotation options do not apply. If format is unknown, or
+// single batch only, each emit fails.
+func NewIOEmitter(writer io.Writer, opts ...FileOption) *fileEmitter {
+	em := &fileEmitter{
+		name:   "file",
 		writer: writer,
+		format: "json",
+	}
+	for _, opt := range opts {
+		opt(em)
 	}
+	em.encoder, em.encErr = newStreamEncoder(em.format)
+	return em
 }
 
-// Emit metrics to stdout
-func NewStdoutEmitter() *fileEmitter {
-	return NewIOEmitter(os.Stdout)
+// Emit metrics to stdout, see NewIOEmitter.
+func NewStdoutEmitter(opts ...FileOption) *fileEmitter {
+	return NewIOEmitter(os.Stdout, opts...)
 }
 
-// Emit metrics to file as json lines.
+// Emit metrics to file or io writer, as json lines by default.
 type fileEmitter struct {
-	writer io.Writer
+	name    string
+	writer  io.Writer
+	format  string // name of encoder format
+	encoder exporters.Encoder
+	encErr  error // error of creating encoder for io writer
+	sent    int64 // bytes written
+
+	// Rotation of file, where path is empty for io writer.
+	path       string
+	maxSize    int64         // rotate when file exceeds size, 0 means no limit
+	interval   time.Duration // rotate when file is older than, 0 means never
+	maxBackups int           // number of rotated files to keep, 0 means all
+	gzip       bool          // compress rotated files
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64     // size of current file
+	opened time.Time // when current file is opened
 }
 
 func (this *fileEmitter) Name() string {
-	// TODO filename?
-	return "file"
+	return this.name
 }
 
 func (this *fileEmitter) Emit(metrics ...*exporters.Metric) error {
-	writer := this.writer
-	for _, metric := range metrics {
-		line, err := json.Marshal(metric)
-		if err != nil {
-			return err
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.path == "" {
+		if this.encErr != nil {
+			return this.encErr
 		}
-		_, err = fmt.Fprintln(writer, string(line))
-		if err != nil {
+		return this.encoder.Encode(&countWriter{w: this.writer, n: &this.sent}, metrics...)
+	}
+	if this.file == nil {
+		return fmt.Errorf("%s is closed", this.name)
+	}
+	var buf bytes.Buffer
+	if err := this.encoder.Encode(&buf, metrics...); err != nil {
+		return err
+	}
+	var rerr error
+	if this.shouldRotate(int64(buf.Len())) {
+		if rerr = this.rotate(); this.file == nil {
+			return rerr
+		}
+		// encode again, in case encoder writes header to each file
+		buf.Reset()
+		if err := this.encoder.Encode(&buf, metrics...); err != nil {
 			return err
 		}
 	}
-	return nil
+	n, err := this.file.Write(buf.Bytes())
+	this.size += int64(n)
+	atomic.AddInt64(&this.sent, int64(n))
+	if err != nil {
+		return err
+	}
+	return rerr
+}
+
+func (this *fileEmitter) BytesSent() int64 {
+	return atomic.LoadInt64(&this.sent)
+}
+
+// Count bytes written to writer.
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddInt64(cw.n, int64(n))
+	return n, err
 }
 
 func (this *fileEmitter) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.path != "" {
+		if this.file == nil {
+			return nil
+		}
+		err := this.file.Close()
+		this.file = nil
+		return err
+	}
 	writer, ok := this.writer.(io.Closer)
 	if ok {
 		return writer.Close()
@@ -70,3 +170,169 @@ func (this *fileEmitter) Close() error {
 		return nil
 	}
 }
+
+// Open file for appending, with a new encoder, which skips header if file is
+// not empty.
+func (this *fileEmitter) open() error {
+	file, err := os.OpenFile(this.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	encoder, err := newStreamEncoder(this.format)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if he, ok := encoder.(exporters.HeaderEncoder); ok && stat.Size() > 0 {
+		// appending to file with header already written
+		he.SkipHeader()
+	}
+	this.file = file
+	this.encoder = encoder
+	this.size = stat.Size()
+	this.opened = time.Now()
+	return nil
+}
+
+// Whether to rotate non-empty file, before writing n bytes.
+func (this *fileEmitter) shouldRotate(n int64) bool {
+	if this.size == 0 {
+		return false
+	}
+	if this.maxSize > 0 && this.size+n > this.maxSize {
+		return true
+	}
+	return this.interval > 0 && time.Since(this.opened) >= this.interval
+}
+
+// Rename current file with timestamp suffix, compress it if enabled, remove
+// backups exceeding max backups, and open a new file. If rotation fails, file
+// is reopened to append, unless it fails to open.
+func (this *fileEmitter) rotate() error {
+	this.file.Close()
+	this.file = nil
+	backup := this.path + "." + time.Now().Format("20060102-150405.000000000")
+	err := os.Rename(this.path, backup)
+	if err == nil && this.gzip {
+		err = gzipFile(backup)
+	}
+	if err == nil {
+		this.prune()
+	}
+	if oerr := this.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+// Suffix of rotated files, i.e. timestamp with optional .gz suffix.
+var backupSuffix = regexp.MustCompile(`^\.\d{8}-\d{6}\.\d{9}(\.gz)?$`)
+
+// Remove oldest backups exceeding max backups, which are files named by path
+// with backup suffix, while other files in same dir are left alone.
+func (this *fileEmitter) prune() {
+	if this.maxBackups <= 0 {
+		return
+	}
+	dir, base := filepath.Split(this.path)
+	entries, _ := os.ReadDir(filepath.Clean(dir))
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, base) && backupSuffix.MatchString(name[len(base):]) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	// timestamp suffix sorts in time order
+	sort.Strings(backups)
+	for len(backups) > this.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// Compress file to path.gz, and remove it.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Create encoder of format, which must not be single batch only, as batches
+// are appended to one stream.
+func newStreamEncoder(format string) (exporters.Encoder, error) {
+	encoder, err := exporters.NewEncoder(format)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := encoder.(exporters.SingleBatchEncoder); ok {
+		return nil, fmt.Errorf("Encoder format %s is single batch only, which can not be appended to stream", format)
+	}
+	return encoder, nil
+}
+
+type FileOption func(*fileEmitter)
+
+// Format of file, one of exporters.EncoderFormats except single batch only
+// ones, i.e. prometheus and openmetrics, default to json.
+func WithFormat(format string) FileOption {
+	return func(em *fileEmitter) {
+		em.format = format
+	}
+}
+
+// Rotate file when it would exceed size in bytes, default to no limit.
+func WithMaxSize(size int64) FileOption {
+	return func(em *fileEmitter) {
+		em.maxSize = size
+	}
+}
+
+// Rotate file when it is older than interval, default to never.
+func WithRotateInterval(du time.Duration) FileOption {
+	return func(em *fileEmitter) {
+		em.interval = du
+	}
+}
+
+// Number of rotated files to keep, default to 0, which keeps all.
+func WithMaxBackups(n int) FileOption {
+	return func(em *fileEmitter) {
+		em.maxBackups = n
+	}
+}
+
+// Compress rotated files with gzip (or not).
+func WithGzip(b bool) FileOption {
+	return func(em *fileEmitter) {
+		em.gzip = b
+	}
+}
@@ -0,0 +1,127 @@
+package emitters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileEmitterAppend(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "metrics.log")
+	metric := &exporters.Metric{Name: "req", Time: time.Unix(1667123357, 0),
+		Fields: map[string]exporters.Value{"count": exporters.IntValue(1)}}
+	for i := 0; i < 2; i++ {
+		em, err := NewFileEmitter(path, WithFormat("influx"))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		assert.Equal("file: "+path, em.Name())
+		if err := em.Emit(metric); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		assert.Nil(em.Close())
+	}
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal("req count=1i 1667123357000000000\nreq count=1i 1667123357000000000\n", string(data))
+
+	_, err := NewFileEmitter(path, WithFormat("xml"))
+	assert.Error(err)
+	for _, format := range []string{"prometheus", "openmetrics"} {
+		_, err = NewFileEmitter(path, WithFormat(format))
+		assert.ErrorContains(err, "single batch only", format)
+	}
+}
+
+func TestFileEmitterAppendCSV(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "metrics.csv")
+	metric := &exporters.Metric{Name: "req", Type: exporters.TypeCounter, Time: time.Unix(1667123357, 0),
+		Fields: map[string]exporters.Value{"count": exporters.IntValue(1)}}
+	for i := 0; i < 2; i++ {
+		em, err := NewFileEmitter(path, WithFormat("csv"))
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := em.Emit(metric); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		assert.Nil(em.Close())
+	}
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal("time,name,type,labels,field,value\n"+
+		"2022-10-30T09:49:17Z,req,counter,,count,1\n"+
+		"2022-10-30T09:49:17Z,req,counter,,count,1\n", string(data), "Should not repeat header")
+}
+
+func TestIOEmitterFormat(t *testing.T) {
+	assert := assert.New(t)
+	metric := &exporters.Metric{Name: "req", Time: time.Unix(1667123357, 0),
+		Fields: map[string]exporters.Value{"count": exporters.IntValue(1)}}
+	var buf bytes.Buffer
+	em := NewIOEmitter(&buf, WithFormat("influx"))
+	assert.Nil(em.Emit(metric))
+	assert.Equal("req count=1i 1667123357000000000\n", buf.String())
+	assert.Equal(int64(buf.Len()), em.BytesSent())
+
+	assert.Error(NewIOEmitter(&buf, WithFormat("xml")).Emit(metric))
+	assert.ErrorContains(NewStdoutEmitter(WithFormat("openmetrics")).Emit(metric), "single batch only")
+}
+
+func TestFileEmitterRotate(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.csv")
+	// files of others, which should not be pruned
+	others := map[string]bool{"metrics.csv.1": true, "metrics.csv.json": true}
+	for name := range others {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	em, err := NewFileEmitter(path, WithFormat("csv"), WithMaxSize(120), WithMaxBackups(2), WithGzip(true))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	metric := &exporters.Metric{Name: "req", Type: exporters.TypeCounter, Time: time.Unix(1667123357, 0),
+		Fields: map[string]exporters.Value{"count": exporters.IntValue(1)}}
+	// each batch is 42 bytes, plus 34 bytes of header, thus 2 batches per file
+	for i := 0; i < 8; i++ {
+		if err := em.Emit(metric); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	var backups []string
+	for _, entry := range entries {
+		if others[entry.Name()] {
+			delete(others, entry.Name())
+		} else if entry.Name() != "metrics.csv" {
+			backups = append(backups, entry.Name())
+		}
+	}
+	assert.Len(backups, 2)
+	assert.Empty(others, "Should keep files of others")
+	data, _ := ioutil.ReadFile(path)
+	assert.True(strings.HasPrefix(string(data), "time,name,type,labels,field,value\n"), "Header in new file")
+
+	file, err := os.Open(filepath.Join(dir, backups[1]))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer file.Close()
+	assert.True(strings.HasSuffix(backups[1], ".gz"))
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	data, _ = ioutil.ReadAll(zr)
+	assert.Equal("time,name,type,labels,field,value\n2022-10-30T09:49:17Z,req,counter,,count,1\n2022-10-30T09:49:17Z,req,counter,,count,1\n", string(data))
+}
@@ -0,0 +1,335 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+)
+
+// Emit metrics to carbon plaintext receiver, usually listening on port 2003.
+// Each field of metric is written as one line:
+//
+//    prefix.name.field value timestamp
+func NewPlaintextEmitter(addr string, opts ...Option) (*graphiteEmitter, error) {
+	return newEmitter(addr, false, opts...)
+}
+
+// Emit metrics to carbon pickle receiver, usually listening on port 2004.
+func NewPickleEmitter(addr string, opts ...Option) (*graphiteEmitter, error) {
+	return newEmitter(addr, true, opts...)
+}
+
+func newEmitter(addr string, pickle bool, opts ...Option) (*graphiteEmitter, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	em := &graphiteEmitter{
+		addr:         addr,
+		pickle:       pickle,
+		dialTimeout:  5 * time.Second,
+		writeTimeout: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(em)
+	}
+	return em, nil
+}
+
+// Tcp based graphite emitter, which keeps a persistent connection to carbon,
+// and reconnects on failure.
+type graphiteEmitter struct {
+	addr         string
+	pickle       bool   // pickle or plaintext protocol
+	prefix       string // prefix to each metric path
+	tags         bool   // encode labels as graphite 1.1 tags
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	sent int64 // bytes sent
+}
+
+func (this *graphiteEmitter) Name() string {
+	if this.pickle {
+		return fmt.Sprintf("graphite-pickle: %s", this.addr)
+	} else {
+		return fmt.Sprintf("graphite: %s", this.addr)
+	}
+}
+
+func (this *graphiteEmitter) BytesSent() int64 {
+	return atomic.LoadInt64(&this.sent)
+}
+
+func (this *graphiteEmitter) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.conn == nil {
+		return nil
+	}
+	err := this.conn.Close()
+	this.conn = nil
+	return err
+}
+
+func (this *graphiteEmitter) Emit(metrics ...*exporters.Metric) error {
+	return this.EmitContext(context.Background(), metrics...)
+}
+
+// Emit metrics, where dial and write return once ctx is done.
+func (this *graphiteEmitter) EmitContext(ctx context.Context, metrics ...*exporters.Metric) error {
+	points := this.points(metrics)
+	if len(points) == 0 {
+		return nil
+	}
+	var payload []byte
+	if this.pickle {
+		payload = encodePickle(points)
+	} else {
+		payload = encodePlaintext(points)
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	n, err := this.write(ctx, payload)
+	if err != nil && n == 0 && ctx.Err() == nil {
+		// connection may be closed by carbon, reconnect and retry once, but
+		// not if partially written, as carbon would get a truncated line
+		// followed by duplicate points
+		_, err = this.write(ctx, payload)
+	}
+	return err
+}
+
+// Write payload on persistent connection, connect if not yet connected, the
+// connection is dropped on failure. It returns bytes written.
+func (this *graphiteEmitter) write(ctx context.Context, payload []byte) (int, error) {
+	if this.conn != nil && !alive(this.conn) {
+		this.conn.Close()
+		this.conn = nil
+	}
+	if this.conn == nil {
+		dialer := &net.Dialer{Timeout: this.dialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", this.addr)
+		if err != nil {
+			return 0, err
+		}
+		this.conn = conn
+	}
+	var deadline time.Time
+	if this.writeTimeout > 0 {
+		deadline = time.Now().Add(this.writeTimeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	this.conn.SetWriteDeadline(deadline)
+	if ctx.Done() != nil {
+		// interrupt write once ctx is cancelled
+		stop := make(chan struct{})
+		defer close(stop)
+		go func(conn net.Conn) {
+			select {
+			case <-ctx.Done():
+				conn.SetWriteDeadline(time.Now())
+			case <-stop:
+			}
+		}(this.conn)
+	}
+	n, err := this.conn.Write(payload)
+	atomic.AddInt64(&this.sent, int64(n))
+	if err != nil {
+		this.conn.Close()
+		this.conn = nil
+		return n, err
+	}
+	return n, nil
+}
+
+// Check if connection is closed by peer, before writing to it. Carbon never
+// writes back, thus a short read either times out or hits EOF.
+func alive(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var one [1]byte
+	_, err := conn.Read(one[:])
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return true
+	}
+	return err == nil
+}
+
+type point struct {
+	path  string
+	value float64
+	ts    int64
+}
+
+// Break metrics down to points, one per field. String and non-finite values
+// are dropped, as carbon can not store them.
+func (this *graphiteEmitter) points(metrics []*exporters.Metric) []point {
+	points := make([]point, 0, len(metrics))
+	for _, metric := range metrics {
+		ts := metric.Time.Unix()
+		if metric.Time.IsZero() {
+			ts = time.Now().Unix()
+		}
+		name := sanitizePath(metric.Name)
+		if this.prefix != "" {
+			name = this.prefix + "." + name
+		}
+		tags := ""
+		if this.tags {
+			tags = encodeTags(metric.Labels)
+		}
+		for _, entry := range exporters.SortByKey(metric.Fields) {
+			f, v := entry.Key, entry.Val.Float()
+			if !entry.Val.IsNumeric() || math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			path := name + "." + sanitizePath(f) + tags
+			points = append(points, point{path: path, value: v, ts: ts})
+		}
+	}
+	return points
+}
+
+var (
+	pathReplacer     = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_")
+	tagNameReplacer  = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_", "!", "_", "^", "_", "=", "_")
+	tagValueReplacer = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_")
+)
+
+func sanitizePath(s string) string {
+	return pathReplacer.Replace(s)
+}
+
+// Encode labels as graphite 1.1 tags, e.g. `;host=node1;region=us-west-2`.
+// Labels with empty value are dropped, as graphite does not allow them.
+// See https://graphite.readthedocs.io/en/latest/tags.html
+func encodeTags(labels map[string]string) string {
+	var sb strings.Builder
+	for _, entry := range exporters.SortByKey(labels) {
+		k := tagNameReplacer.Replace(entry.Key)
+		v := strings.TrimLeft(tagValueReplacer.Replace(entry.Val), "~")
+		if k == "" || v == "" {
+			continue
+		}
+		sb.WriteString(";")
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(v)
+	}
+	return sb.String()
+}
+
+func encodePlaintext(points []point) []byte {
+	var buf bytes.Buffer
+	for _, p := range points {
+		buf.WriteString(p.path)
+		buf.WriteString(" ")
+		buf.WriteString(strconv.FormatFloat(p.value, 'f', -1, 64))
+		buf.WriteString(" ")
+		buf.WriteString(strconv.FormatInt(p.ts, 10))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// Max points in one pickle message.
+const pickleBatchSize = 500
+
+// Encode points as pickle messages, each is a length header followed by a
+// pickled list of (path, (timestamp, value)) tuples, using pickle protocol 2.
+// See https://graphite.readthedocs.io/en/latest/feeding-carbon.html#the-pickle-protocol
+func encodePickle(points []point) []byte {
+	var buf bytes.Buffer
+	for len(points) > 0 {
+		n := len(points)
+		if n > pickleBatchSize {
+			n = pickleBatchSize
+		}
+		msg := pickleList(points[:n])
+		binary.Write(&buf, binary.BigEndian, uint32(len(msg)))
+		buf.Write(msg)
+		points = points[n:]
+	}
+	return buf.Bytes()
+}
+
+// Pickle opcodes, see python Lib/pickle.py
+const (
+	opProto     = 0x80
+	opEmptyList = ']'
+	opMark      = '('
+	opAppends   = 'e'
+	opBinUni    = 'X'
+	opBinInt    = 'J'
+	opLong1     = 0x8a
+	opBinFloat  = 'G'
+	opTuple2    = 0x86
+	opStop      = '.'
+)
+
+func pickleList(points []point) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{opProto, 2, opEmptyList, opMark})
+	for _, p := range points {
+		buf.WriteByte(opBinUni)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(p.path)))
+		buf.WriteString(p.path)
+		if p.ts >= math.MinInt32 && p.ts <= math.MaxInt32 {
+			buf.WriteByte(opBinInt)
+			binary.Write(&buf, binary.LittleEndian, int32(p.ts))
+		} else {
+			buf.Write([]byte{opLong1, 8})
+			binary.Write(&buf, binary.LittleEndian, p.ts)
+		}
+		buf.WriteByte(opBinFloat)
+		binary.Write(&buf, binary.BigEndian, math.Float64bits(p.value))
+		buf.Write([]byte{opTuple2, opTuple2})
+	}
+	buf.Write([]byte{opAppends, opStop})
+	return buf.Bytes()
+}
+
+type Option func(*graphiteEmitter)
+
+// Prefix to each metric path, joined by dot.
+func WithPrefix(prefix string) Option {
+	return func(em *graphiteEmitter) {
+		em.prefix = prefix
+	}
+}
+
+// Encode labels as graphite 1.1 tags, e.g. `name.field;host=node1`, default
+// to false, where labels are dropped.
+func WithTags(b bool) Option {
+	return func(em *graphiteEmitter) {
+		em.tags = b
+	}
+}
+
+// Tcp dial timeout, default to 5s.
+func WithDialTimeout(du time.Duration) Option {
+	return func(em *graphiteEmitter) {
+		em.dialTimeout = du
+	}
+}
+
+// Tcp write timeout, default to 5s.
+func WithWriteTimeout(du time.Duration) Option {
+	return func(em *graphiteEmitter) {
+		em.writeTimeout = du
+	}
+}
@@ -0,0 +1,143 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/stretchr/testify/assert"
+)
+
+// Start a carbon server, which sends each accepted connection to conns.
+func startCarbon(t *testing.T) (net.Listener, chan net.Conn) {
+	li, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := li.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return li, conns
+}
+
+var metric = &exporters.Metric{
+	Name:   "req latency",
+	Type:   exporters.TypeTimer,
+	Time:   time.Unix(1667123357, 0),
+	Labels: map[string]string{"host": "node 1", "region": "~us;west", "empty": ""},
+	Fields: map[string]exporters.Value{"count": exporters.FloatValue(3), "p99": exporters.FloatValue(1.5)},
+}
+
+func TestPlaintext(t *testing.T) {
+	assert := assert.New(t)
+	li, conns := startCarbon(t)
+	defer li.Close()
+	em, err := NewPlaintextEmitter(li.Addr().String(), WithPrefix("app"), WithTags(true))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	assert.Equal("graphite: "+li.Addr().String(), em.Name())
+
+	if err := em.Emit(metric); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conn := <-conns
+	reader := bufio.NewReader(conn)
+	line1, _ := reader.ReadString('\n')
+	line2, _ := reader.ReadString('\n')
+	assert.Equal("app.req_latency.count;host=node_1;region=us_west 3 1667123357\n", line1)
+	assert.Equal("app.req_latency.p99;host=node_1;region=us_west 1.5 1667123357\n", line2)
+
+	// carbon restarts, should reconnect
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	if err := em.Emit(metric); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	select {
+	case conn = <-conns:
+	case <-time.After(time.Second):
+		t.Fatalf("Should reconnect to carbon")
+	}
+	line1, _ = bufio.NewReader(conn).ReadString('\n')
+	assert.Equal("app.req_latency.count;host=node_1;region=us_west 3 1667123357\n", line1)
+}
+
+func TestPickle(t *testing.T) {
+	assert := assert.New(t)
+	li, conns := startCarbon(t)
+	defer li.Close()
+	em, err := NewPickleEmitter(li.Addr().String())
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	if err := em.Emit(metric); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conn := <-conns
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	// [("req_latency.count", (1667123357, 3.0)), ("req_latency.p99", (1667123357, 1.5))]
+	expected := "\x80\x02](" +
+		"X\x11\x00\x00\x00req_latency.count" + "J\x9dH^c" + "G\x40\x08\x00\x00\x00\x00\x00\x00" + "\x86\x86" +
+		"X\x0f\x00\x00\x00req_latency.p99" + "J\x9dH^c" + "G\x3f\xf8\x00\x00\x00\x00\x00\x00" + "\x86\x86" +
+		"e."
+	assert.Equal(expected, string(msg))
+}
+
+func TestEmitDeadline(t *testing.T) {
+	assert := assert.New(t)
+	li, conns := startCarbon(t)
+	defer li.Close()
+	em, err := NewPlaintextEmitter(li.Addr().String())
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	// large enough to fill socket buffers, as carbon never reads
+	fields := make(map[string]exporters.Value, 1<<19)
+	for i := 0; i < 1<<19; i++ {
+		fields[strconv.Itoa(i)] = exporters.FloatValue(float64(i))
+	}
+	big := &exporters.Metric{Name: "big", Time: time.Unix(1667123357, 0), Fields: fields}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accepted := make(chan time.Time, 1)
+	go func() {
+		<-conns
+		accepted <- time.Now()
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	err = em.EmitContext(ctx, big)
+	assert.Less(time.Since(<-accepted), time.Second)
+	assert.ErrorIs(err, os.ErrDeadlineExceeded)
+	assert.Greater(em.BytesSent(), int64(0))
+	select {
+	case <-conns:
+		t.Fatalf("Should not retry after partial write")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -2,12 +2,16 @@ package influx
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	exporters "github.com/juvenn/metric-exporters"
@@ -46,6 +50,7 @@ func newEmitter(writeUrl string, opts ...Option) (*influxEmitter, error) {
 		writeUrl:  url,
 		precision: "s",
 		params:    url.Query(),
+		retry:     retryPolicy{attempts: 1},
 		http: &http.Client{
 			Timeout: 5 * time.Second,
 		},
@@ -78,7 +83,9 @@ type influxEmitter struct {
 	org       string // v2
 	bucket    string // v2
 
-	http *http.Client
+	retry retryPolicy
+	http  *http.Client
+	sent  int64 // bytes sent
 }
 
 func (this *influxEmitter) Name() string {
@@ -89,21 +96,32 @@ func (this *influxEmitter) Name() string {
 	}
 }
 
+func (this *influxEmitter) BytesSent() int64 {
+	return atomic.LoadInt64(&this.sent)
+}
+
 func (this *influxEmitter) Close() error {
 	return nil
 }
 
 func (this *influxEmitter) Emit(metrics ...*exporters.Metric) error {
+	return this.EmitContext(context.Background(), metrics...)
+}
+
+func (this *influxEmitter) EmitContext(ctx context.Context, metrics ...*exporters.Metric) error {
 	if len(metrics) == 0 {
 		return nil
 	}
 	var lines strings.Builder
 	for _, metric := range metrics {
 		line := metric.EncodeInfluxLine(this.precision)
+		if line == "" {
+			continue
+		}
 		lines.WriteString(line)
 		lines.WriteString("\n")
 	}
-	return this.request(bytes.NewBufferString(lines.String()))
+	return this.requestWithRetry(ctx, []byte(lines.String()))
 }
 
 func (this *influxEmitter) buildUrl() string {
@@ -112,8 +130,39 @@ func (this *influxEmitter) buildUrl() string {
 	return url.String()
 }
 
-func (this *influxEmitter) request(body io.Reader) error {
-	req, err := http.NewRequest(http.MethodPost, this.buildUrl(), body)
+// Request with retry on network error, 429 and 5xx, while 4xx such as line
+// protocol error is never retried. Retry-After is honored, unless it exceeds
+// max backoff, in which case error is returned without retry.
+func (this *influxEmitter) requestWithRetry(ctx context.Context, body []byte) error {
+	for attempt := 1; ; attempt++ {
+		err := this.request(ctx, bytes.NewReader(body))
+		if err == nil || attempt >= this.retry.attempts || ctx.Err() != nil {
+			return err
+		}
+		delay := this.retry.backoff(attempt)
+		if err, ok := err.(*statusError); ok {
+			if !err.retryable() {
+				return err
+			}
+			if err.retryAfter > this.retry.maxBackoff {
+				return err
+			}
+			if err.retryAfter >= 0 {
+				delay = err.retryAfter
+			}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (this *influxEmitter) request(ctx context.Context, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, this.buildUrl(), body)
 	if err != nil {
 		return err
 	}
@@ -133,14 +182,79 @@ func (this *influxEmitter) request(body io.Reader) error {
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&this.sent, req.ContentLength)
+	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		defer resp.Body.Close()
 		bstr, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("%s %s %s %s", http.MethodPost, this.writeUrl, resp.Status, string(bstr))
+		return &statusError{
+			status:     resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			msg:        fmt.Sprintf("%s %s %s %s", http.MethodPost, this.writeUrl, resp.Status, string(bstr)),
+		}
 	}
 	return nil
 }
 
+// Error of non-2xx response.
+type statusError struct {
+	status     int
+	retryAfter time.Duration // -1 if not specified
+	msg        string
+}
+
+func (err *statusError) Error() string {
+	return err.msg
+}
+
+func (err *statusError) retryable() bool {
+	return err.status == http.StatusTooManyRequests || err.status >= 500
+}
+
+// Parse Retry-After header, either in seconds or http date, return -1 if
+// absent or invalid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return -1
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return -1
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		du := time.Until(t)
+		if du < 0 {
+			du = 0
+		}
+		return du
+	}
+	return -1
+}
+
+type retryPolicy struct {
+	attempts   int           // max attempts, including the first one
+	minBackoff time.Duration // backoff of first retry
+	maxBackoff time.Duration // cap of backoff
+}
+
+// Exponential backoff with jitter, i.e. random in [d/2, d), where d doubles
+// on each attempt, up to max backoff.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.minBackoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
 type Option func(*influxEmitter)
 
 // ### Common options
@@ -177,6 +291,20 @@ func WithRequestTimeout(du time.Duration) Option {
 	}
 }
 
+// Retry failed request on network error, 429 and 5xx, up to max attempts
+// (including the first one), with exponential backoff starting from min
+// backoff and capped at max backoff, unless server specifies Retry-After, and
+// no retry if Retry-After exceeds max backoff. Default to no retry. NOTE that
+// retries are bounded by emit deadline, see exporters.Reporter.WithEmitTimeout.
+func WithRetry(attempts int, minBackoff, maxBackoff time.Duration) Option {
+	return func(em *influxEmitter) {
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+		em.retry = retryPolicy{attempts: attempts, minBackoff: minBackoff, maxBackoff: maxBackoff}
+	}
+}
+
 // ### V2 options
 
 // Influx API token, v2 only.
@@ -79,3 +79,82 @@ func startInfluxServer(handle func(w http.ResponseWriter, req *http.Request)) *h
 	srv.Start()
 	return srv
 }
+
+func TestRetry(t *testing.T) {
+	assert := assert.New(t)
+	statuses := []int{503, 429, 500, 204}
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		bstr, _ := ioutil.ReadAll(req.Body)
+		assert.Equal("req count=1 1667123357\n", string(bstr))
+		if statuses[attempts] == 429 {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(statuses[attempts])
+		attempts++
+	}))
+	defer srv.Close()
+	em, _ := NewV1Emitter(srv.URL, "req", WithRetry(4, time.Millisecond, 10*time.Millisecond))
+	metric := &exporters.Metric{Name: "req", Time: time.Unix(1667123357, 0), Fields: map[string]exporters.Value{"count": exporters.FloatValue(1)}}
+	assert.Nil(em.Emit(metric))
+	assert.Equal(4, attempts)
+
+	// exhausted
+	attempts = 0
+	em, _ = NewV1Emitter(srv.URL, "req", WithRetry(2, time.Millisecond, 10*time.Millisecond))
+	assert.ErrorContains(em.Emit(metric), "429")
+	assert.Equal(2, attempts)
+}
+
+func TestNoRetryBeyondMaxBackoff(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+	em, _ := NewV1Emitter(srv.URL, "req", WithRetry(3, time.Millisecond, time.Second))
+	start := time.Now()
+	err := em.Emit(&exporters.Metric{Name: "req", Fields: map[string]exporters.Value{"count": exporters.FloatValue(1)}})
+	assert.Less(time.Since(start), time.Second)
+	assert.ErrorContains(err, "503")
+	assert.Equal(1, attempts)
+}
+
+func TestNoRetryOnBadRequest(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		w.WriteHeader(400)
+		w.Write([]byte("unable to parse"))
+	}))
+	defer srv.Close()
+	em, _ := NewV1Emitter(srv.URL, "req", WithRetry(3, time.Millisecond, time.Millisecond))
+	err := em.Emit(&exporters.Metric{Name: "req", Fields: map[string]exporters.Value{"count": exporters.FloatValue(1)}})
+	assert.ErrorContains(err, "unable to parse")
+	assert.Equal(1, attempts)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(time.Duration(-1), parseRetryAfter(""))
+	assert.Equal(time.Duration(-1), parseRetryAfter("soon"))
+	assert.Equal(120*time.Second, parseRetryAfter("120"))
+	assert.Equal(time.Duration(0), parseRetryAfter("Wed, 21 Oct 2015 07:28:00 GMT"))
+	du := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(du > 58*time.Second && du <= time.Minute, du)
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{attempts: 5, minBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		du := p.backoff(attempt + 1)
+		if du < max/2 || du >= max {
+			t.Errorf("Backoff of attempt %d should be in [%s, %s) but got %s", attempt+1, max/2, max, du)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package otlp
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A subset of OTLP metrics data model, which is encoded as either json or
+// protobuf.
+// See https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+
+// Aggregation temporality of sum
+const (
+	temporalityDelta      = 1
+	temporalityCumulative = 2
+)
+
+type exportRequest struct {
+	ResourceMetrics []*resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource        `json:"resource"`
+	ScopeMetrics []*scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeMetrics struct {
+	Scope   scope     `json:"scope"`
+	Metrics []*metric `json:"metrics"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type metric struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Unit        string   `json:"unit,omitempty"`
+	Gauge       *gauge   `json:"gauge,omitempty"`
+	Sum         *sum     `json:"sum,omitempty"`
+	Summary     *summary `json:"summary,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []*numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []*numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                `json:"aggregationTemporality"`
+	IsMonotonic            bool               `json:"isMonotonic"`
+}
+
+type summary struct {
+	DataPoints []*summaryDataPoint `json:"dataPoints"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64     `json:"timeUnixNano,string"`
+	AsDouble          *double    `json:"asDouble,omitempty"` // one of AsDouble and AsInt
+	AsInt             *int64     `json:"asInt,omitempty,string"`
+}
+
+type summaryDataPoint struct {
+	Attributes        []keyValue        `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64            `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64            `json:"timeUnixNano,string"`
+	Count             uint64            `json:"count,string"`
+	Sum               double            `json:"sum"`
+	QuantileValues    []valueAtQuantile `json:"quantileValues,omitempty"`
+}
+
+type valueAtQuantile struct {
+	Quantile double `json:"quantile"`
+	Value    double `json:"value"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// A double encodes NaN and infinity as strings in json, as per proto3 json
+// mapping.
+type double float64
+
+func (d double) MarshalJSON() ([]byte, error) {
+	f := float64(d)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(f)
+}
+
+func (d *double) UnmarshalJSON(data []byte) error {
+	var f float64
+	if err := json.Unmarshal(data, &f); err == nil {
+		*d = double(f)
+		return nil
+	}
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return err
+	}
+	f, err = strconv.ParseFloat(s, 64)
+	*d = double(f)
+	return err
+}
+
+// ### Protobuf encoding
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v double) []byte {
+	return appendFixed64(b, num, math.Float64bits(float64(v)))
+}
+
+func (req *exportRequest) marshalProto() []byte {
+	var b []byte
+	for _, rm := range req.ResourceMetrics {
+		b = appendMessage(b, 1, rm.marshalProto())
+	}
+	return b
+}
+
+func (rm *resourceMetrics) marshalProto() []byte {
+	var b []byte
+	b = appendMessage(b, 1, rm.Resource.marshalProto())
+	for _, sm := range rm.ScopeMetrics {
+		b = appendMessage(b, 2, sm.marshalProto())
+	}
+	return b
+}
+
+func (res *resource) marshalProto() []byte {
+	var b []byte
+	for _, kv := range res.Attributes {
+		b = appendMessage(b, 1, kv.marshalProto())
+	}
+	return b
+}
+
+func (sm *scopeMetrics) marshalProto() []byte {
+	var b []byte
+	b = appendMessage(b, 1, sm.Scope.marshalProto())
+	for _, m := range sm.Metrics {
+		b = appendMessage(b, 2, m.marshalProto())
+	}
+	return b
+}
+
+func (sc *scope) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, sc.Name)
+	b = appendString(b, 2, sc.Version)
+	return b
+}
+
+func (m *metric) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Description)
+	b = appendString(b, 3, m.Unit)
+	switch {
+	case m.Gauge != nil:
+		b = appendMessage(b, 5, m.Gauge.marshalProto())
+	case m.Sum != nil:
+		b = appendMessage(b, 7, m.Sum.marshalProto())
+	case m.Summary != nil:
+		b = appendMessage(b, 11, m.Summary.marshalProto())
+	}
+	return b
+}
+
+func (g *gauge) marshalProto() []byte {
+	var b []byte
+	for _, dp := range g.DataPoints {
+		b = appendMessage(b, 1, dp.marshalProto())
+	}
+	return b
+}
+
+func (s *sum) marshalProto() []byte {
+	var b []byte
+	for _, dp := range s.DataPoints {
+		b = appendMessage(b, 1, dp.marshalProto())
+	}
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.AggregationTemporality))
+	if s.IsMonotonic {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func (s *summary) marshalProto() []byte {
+	var b []byte
+	for _, dp := range s.DataPoints {
+		b = appendMessage(b, 1, dp.marshalProto())
+	}
+	return b
+}
+
+func (dp *numberDataPoint) marshalProto() []byte {
+	var b []byte
+	b = appendFixed64(b, 2, dp.StartTimeUnixNano)
+	b = appendFixed64(b, 3, dp.TimeUnixNano)
+	if dp.AsInt != nil {
+		b = appendFixed64(b, 6, uint64(*dp.AsInt))
+	} else if dp.AsDouble != nil {
+		b = appendDouble(b, 4, *dp.AsDouble)
+	}
+	for _, kv := range dp.Attributes {
+		b = appendMessage(b, 7, kv.marshalProto())
+	}
+	return b
+}
+
+func (dp *summaryDataPoint) marshalProto() []byte {
+	var b []byte
+	b = appendFixed64(b, 2, dp.StartTimeUnixNano)
+	b = appendFixed64(b, 3, dp.TimeUnixNano)
+	b = appendFixed64(b, 4, dp.Count)
+	b = appendDouble(b, 5, dp.Sum)
+	for _, q := range dp.QuantileValues {
+		var qb []byte
+		qb = appendDouble(qb, 1, q.Quantile)
+		qb = appendDouble(qb, 2, q.Value)
+		b = appendMessage(b, 6, qb)
+	}
+	for _, kv := range dp.Attributes {
+		b = appendMessage(b, 7, kv.marshalProto())
+	}
+	return b
+}
+
+func (kv *keyValue) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, 1, kv.Key)
+	var vb []byte
+	vb = protowire.AppendTag(vb, 1, protowire.BytesType)
+	vb = protowire.AppendString(vb, kv.Value.StringValue)
+	b = appendMessage(b, 2, vb)
+	return b
+}
@@ -0,0 +1,394 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+)
+
+// Encodings of export request
+const (
+	EncodingProtobuf = "protobuf"
+	EncodingJSON     = "json"
+)
+
+// Create an emitter to export metrics to OTLP/HTTP endpoint, e.g.
+//
+//    http://localhost:4318/v1/metrics
+//
+// Metrics are mapped as:
+//
+//    counter          => monotonic cumulative sum
+//    gauge            => gauge
+//    meter            => monotonic cumulative sum of count, and gauge of each rate
+//    timer, histogram => summary with quantiles, and gauge of each remaining field
+//
+// If reporter reports deltas, sums are of delta temporality instead, and each
+// data point starts from last emit, see exporters.Reporter.WithDelta. As OTLP
+// summary is cumulative only, timer and histogram are mapped as delta sum of
+// count, and gauge of each remaining field, in delta mode.
+//
+// Reporter global labels are encoded as resource attributes, other labels as
+// data point attributes.
+func NewEmitter(endpoint string, opts ...Option) (*otlpEmitter, error) {
+	url, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	em := &otlpEmitter{
+		endpoint: url,
+		encoding: EncodingProtobuf,
+		resource: make(map[string]string),
+		headers:  make(http.Header),
+		start:    time.Now(),
+		http: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+	for _, opt := range opts {
+		opt(em)
+	}
+	if em.encoding != EncodingProtobuf && em.encoding != EncodingJSON {
+		return nil, fmt.Errorf("OTLP encoding must be one of [protobuf,json]")
+	}
+	return em, nil
+}
+
+// Http based OTLP metrics emitter.
+// See https://opentelemetry.io/docs/specs/otlp/#otlphttp
+type otlpEmitter struct {
+	endpoint *url.URL
+	encoding string            // protobuf or json
+	resource map[string]string // resource attributes
+	headers  http.Header       // extra headers, such as api key
+	start    time.Time         // start time of cumulative data points
+	delta    bool              // counts are deltas since last emit
+	last     time.Time         // time of last emit in delta mode
+
+	http *http.Client
+	sent int64 // bytes sent
+}
+
+func (this *otlpEmitter) Name() string {
+	return fmt.Sprintf("otlp: %s", this.endpoint.String())
+}
+
+func (this *otlpEmitter) BytesSent() int64 {
+	return atomic.LoadInt64(&this.sent)
+}
+
+func (this *otlpEmitter) Close() error {
+	return nil
+}
+
+// Encode sums with delta temporality if delta is true.
+func (this *otlpEmitter) SetDelta(delta bool) error {
+	this.delta = delta
+	return nil
+}
+
+// Merge reporter global labels into resource attributes.
+func (this *otlpEmitter) SetResource(labels map[string]string) {
+	for k, v := range labels {
+		if _, ok := this.resource[k]; !ok {
+			this.resource[k] = v
+		}
+	}
+}
+
+func (this *otlpEmitter) Emit(metrics ...*exporters.Metric) error {
+	return this.EmitContext(context.Background(), metrics...)
+}
+
+func (this *otlpEmitter) EmitContext(ctx context.Context, metrics ...*exporters.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	req := this.exportRequest(metrics)
+	var body []byte
+	var contentType string
+	if this.encoding == EncodingJSON {
+		bstr, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body, contentType = bstr, "application/json"
+	} else {
+		body, contentType = req.marshalProto(), "application/x-protobuf"
+	}
+	return this.request(ctx, body, contentType)
+}
+
+func (this *otlpEmitter) request(ctx context.Context, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, this.endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range this.headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "metrics-exporter/0.1.0")
+	resp, err := this.http.Do(req)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&this.sent, int64(len(body)))
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		bstr, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s %s %s", http.MethodPost, this.endpoint, resp.Status, string(bstr))
+	}
+	return nil
+}
+
+// Build export request of one resource and one scope, data points of same
+// name are grouped into one metric, in order of first appearance.
+func (this *otlpEmitter) exportRequest(metrics []*exporters.Metric) *exportRequest {
+	b := &requestBuilder{
+		start:       uint64(this.start.UnixNano()),
+		temporality: temporalityCumulative,
+		index:       make(map[string]*metric),
+	}
+	if this.delta {
+		// data points start from last emit
+		end := metrics[0].Time
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if !this.last.IsZero() {
+			b.start = uint64(this.last.UnixNano())
+		}
+		b.temporality = temporalityDelta
+		this.last = end
+	}
+	for _, m := range metrics {
+		b.add(m, this.attributes(m.Labels))
+	}
+	res := resource{}
+	for _, entry := range exporters.SortByKey(this.resource) {
+		res.Attributes = append(res.Attributes, stringAttr(entry.Key, entry.Val))
+	}
+	return &exportRequest{
+		ResourceMetrics: []*resourceMetrics{{
+			Resource: res,
+			ScopeMetrics: []*scopeMetrics{{
+				Scope:   scope{Name: "github.com/juvenn/metric-exporters"},
+				Metrics: b.metrics,
+			}},
+		}},
+	}
+}
+
+// Data point attributes, excluding those already in resource.
+func (this *otlpEmitter) attributes(labels map[string]string) []keyValue {
+	var attrs []keyValue
+	for _, entry := range exporters.SortByKey(labels) {
+		if v, ok := this.resource[entry.Key]; ok && v == entry.Val {
+			continue
+		}
+		attrs = append(attrs, stringAttr(entry.Key, entry.Val))
+	}
+	return attrs
+}
+
+func stringAttr(k, v string) keyValue {
+	return keyValue{Key: k, Value: anyValue{StringValue: v}}
+}
+
+type requestBuilder struct {
+	start       uint64 // start time of sum and summary data points
+	temporality int    // aggregation temporality of sum
+	metrics     []*metric
+	index       map[string]*metric
+}
+
+func (b *requestBuilder) metric(name string, init func(*metric)) *metric {
+	m, ok := b.index[name]
+	if !ok {
+		m = &metric{Name: name}
+		init(m)
+		b.index[name] = m
+		b.metrics = append(b.metrics, m)
+	}
+	return m
+}
+
+func (b *requestBuilder) addSum(name string, attrs []keyValue, ts uint64, v exporters.Value) {
+	if !v.IsNumeric() {
+		return
+	}
+	m := b.metric(name, func(m *metric) {
+		m.Sum = &sum{AggregationTemporality: b.temporality, IsMonotonic: true}
+	})
+	if m.Sum == nil {
+		return
+	}
+	dp := &numberDataPoint{Attributes: attrs, StartTimeUnixNano: b.start, TimeUnixNano: ts}
+	dp.setValue(v)
+	m.Sum.DataPoints = append(m.Sum.DataPoints, dp)
+}
+
+func (b *requestBuilder) addGauge(name string, attrs []keyValue, ts uint64, v exporters.Value) {
+	if !v.IsNumeric() {
+		return
+	}
+	m := b.metric(name, func(m *metric) {
+		m.Gauge = &gauge{}
+	})
+	if m.Gauge == nil {
+		return
+	}
+	dp := &numberDataPoint{Attributes: attrs, TimeUnixNano: ts}
+	dp.setValue(v)
+	m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+}
+
+// Set value as int if it is integer or bool, and fits in int64, otherwise as
+// double.
+func (dp *numberDataPoint) setValue(v exporters.Value) {
+	switch v.Kind() {
+	case exporters.KindInt, exporters.KindBool:
+		i := v.Int()
+		dp.AsInt = &i
+		return
+	case exporters.KindUint:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			i := int64(u)
+			dp.AsInt = &i
+			return
+		}
+	}
+	d := double(v.Float())
+	dp.AsDouble = &d
+}
+
+func (b *requestBuilder) add(m *exporters.Metric, attrs []keyValue) {
+	ts := uint64(m.Time.UnixNano())
+	if m.Time.IsZero() {
+		ts = uint64(time.Now().UnixNano())
+	}
+	switch m.Type {
+	case exporters.TypeCounter:
+		for _, entry := range exporters.SortByKey(m.Fields) {
+			name := m.Name
+			if entry.Key != "count" {
+				name = m.Name + "." + entry.Key
+			}
+			b.addSum(name, attrs, ts, entry.Val)
+		}
+	case exporters.TypeGauge:
+		for _, entry := range exporters.SortByKey(m.Fields) {
+			name := m.Name
+			if entry.Key != "gauge" {
+				name = m.Name + "." + entry.Key
+			}
+			b.addGauge(name, attrs, ts, entry.Val)
+		}
+	case exporters.TypeTimer, exporters.TypeHistogram:
+		if b.temporality == temporalityDelta {
+			b.addFields(m, attrs, ts)
+			return
+		}
+		dp := &summaryDataPoint{Attributes: attrs, StartTimeUnixNano: b.start, TimeUnixNano: ts}
+		count, hasCount := m.Fields["count"]
+		mean := m.Fields["mean"]
+		dp.Count = count.Uint()
+		dp.Sum = double(count.Float() * mean.Float())
+		for _, entry := range exporters.SortByKey(m.Fields) {
+			f, v := entry.Key, entry.Val
+			if q, ok := quantile(f); ok && v.IsNumeric() {
+				dp.QuantileValues = append(dp.QuantileValues, valueAtQuantile{double(q), double(v.Float())})
+				continue
+			}
+			switch f {
+			case "count", "mean":
+			default:
+				b.addGauge(m.Name+"."+f, attrs, ts, v)
+			}
+		}
+		sortQuantiles(dp.QuantileValues)
+		if !hasCount {
+			return
+		}
+		sm := b.metric(m.Name, func(m *metric) {
+			m.Summary = &summary{}
+		})
+		if sm.Summary != nil {
+			sm.Summary.DataPoints = append(sm.Summary.DataPoints, dp)
+		}
+	default:
+		b.addFields(m, attrs, ts)
+	}
+}
+
+// Add count field as sum, and each remaining field as gauge.
+func (b *requestBuilder) addFields(m *exporters.Metric, attrs []keyValue, ts uint64) {
+	for _, entry := range exporters.SortByKey(m.Fields) {
+		if entry.Key == "count" {
+			b.addSum(m.Name+".count", attrs, ts, entry.Val)
+		} else {
+			b.addGauge(m.Name+"."+entry.Key, attrs, ts, entry.Val)
+		}
+	}
+}
+
+// Parse quantile from percentile field, e.g. p50 => 0.5, p999 => 0.999, while
+// min and max are regarded as quantile 0 and 1.
+func quantile(field string) (float64, bool) {
+	switch field {
+	case "min":
+		return 0, true
+	case "max":
+		return 1, true
+	}
+	return exporters.ParsePercentile(field)
+}
+
+func sortQuantiles(qs []valueAtQuantile) {
+	sort.Slice(qs, func(i, j int) bool {
+		return qs[i].Quantile < qs[j].Quantile
+	})
+}
+
+type Option func(*otlpEmitter)
+
+// Encoding of export request, can be one of [protobuf,json], default to protobuf.
+func WithEncoding(enc string) Option {
+	return func(em *otlpEmitter) {
+		em.encoding = enc
+	}
+}
+
+// Add resource attribute, such as service.name.
+func WithResourceAttribute(k, v string) Option {
+	return func(em *otlpEmitter) {
+		em.resource[k] = v
+	}
+}
+
+// Add extra header to each request, e.g. api key.
+func WithHeader(k, v string) Option {
+	return func(em *otlpEmitter) {
+		em.headers.Add(k, v)
+	}
+}
+
+// Http request timeout, default to 5s.
+func WithRequestTimeout(du time.Duration) Option {
+	return func(em *otlpEmitter) {
+		em.http.Timeout = du
+	}
+}
@@ -0,0 +1,173 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var batch = []*exporters.Metric{
+	{Name: "req", Type: exporters.TypeCounter, Time: time.Unix(1667123357, 0),
+		Labels: map[string]string{"host": "node1", "method": "GET"},
+		Fields: map[string]exporters.Value{"count": exporters.FloatValue(3)}},
+	{Name: "req", Type: exporters.TypeCounter, Time: time.Unix(1667123357, 0),
+		Labels: map[string]string{"host": "node1", "method": "POST"},
+		Fields: map[string]exporters.Value{"count": exporters.FloatValue(1)}},
+	{Name: "temp", Type: exporters.TypeGauge, Time: time.Unix(1667123357, 0),
+		Labels: map[string]string{"host": "node1"},
+		Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(36.6)}},
+	{Name: "latency", Type: exporters.TypeTimer, Time: time.Unix(1667123357, 0),
+		Labels: map[string]string{"host": "node1"},
+		Fields: map[string]exporters.Value{"count": exporters.FloatValue(4), "mean": exporters.FloatValue(2.5), "min": exporters.FloatValue(1), "max": exporters.FloatValue(4), "p50": exporters.FloatValue(2), "p99": exporters.FloatValue(4), "m1": exporters.FloatValue(0.5)}},
+}
+
+func TestExportRequest(t *testing.T) {
+	assert := assert.New(t)
+	em, err := NewEmitter("http://localhost:4318/v1/metrics", WithResourceAttribute("service.name", "app"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	em.SetResource(map[string]string{"host": "node1"})
+	req := em.exportRequest(batch)
+	assert.Len(req.ResourceMetrics, 1)
+	rm := req.ResourceMetrics[0]
+	assert.Equal([]keyValue{stringAttr("host", "node1"), stringAttr("service.name", "app")}, rm.Resource.Attributes)
+	ms := rm.ScopeMetrics[0].Metrics
+	assert.Len(ms, 4)
+
+	assert.Equal("req", ms[0].Name)
+	assert.True(ms[0].Sum.IsMonotonic)
+	assert.Equal(temporalityCumulative, ms[0].Sum.AggregationTemporality)
+	assert.Len(ms[0].Sum.DataPoints, 2)
+	assert.Equal([]keyValue{stringAttr("method", "POST")}, ms[0].Sum.DataPoints[1].Attributes)
+	assert.Equal(double(1), *ms[0].Sum.DataPoints[1].AsDouble)
+
+	assert.Equal("temp", ms[1].Name)
+	assert.Equal(double(36.6), *ms[1].Gauge.DataPoints[0].AsDouble)
+	assert.Nil(ms[1].Gauge.DataPoints[0].Attributes)
+
+	assert.Equal("latency.m1", ms[2].Name)
+	assert.NotNil(ms[2].Gauge)
+
+	assert.Equal("latency", ms[3].Name)
+	dp := ms[3].Summary.DataPoints[0]
+	assert.Equal(uint64(4), dp.Count)
+	assert.Equal(double(10), dp.Sum)
+	assert.Equal(uint64(1667123357000000000), dp.TimeUnixNano)
+	assert.Equal([]valueAtQuantile{{0, 1}, {0.5, 2}, {0.99, 4}, {1, 4}}, dp.QuantileValues)
+}
+
+func TestDeltaTemporality(t *testing.T) {
+	assert := assert.New(t)
+	em, err := NewEmitter("http://localhost:4318/v1/metrics")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Nil(em.SetDelta(true))
+	ms := em.exportRequest(batch).ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal(temporalityDelta, ms[0].Sum.AggregationTemporality)
+	assert.Equal(uint64(em.start.UnixNano()), ms[0].Sum.DataPoints[0].StartTimeUnixNano)
+	for _, m := range ms {
+		assert.Nil(m.Summary, "Should not encode deltas as cumulative summary")
+	}
+	names := make(map[string]*metric)
+	for _, m := range ms {
+		names[m.Name] = m
+	}
+	assert.Equal(temporalityDelta, names["latency.count"].Sum.AggregationTemporality)
+	assert.NotNil(names["latency.p99"].Gauge)
+
+	next := []*exporters.Metric{batch[0].Clone()}
+	next[0].Time = next[0].Time.Add(10 * time.Second)
+	ms = em.exportRequest(next).ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal(uint64(1667123357000000000), ms[0].Sum.DataPoints[0].StartTimeUnixNano, "Should start from last emit")
+	assert.Equal(uint64(1667123367000000000), ms[0].Sum.DataPoints[0].TimeUnixNano)
+}
+
+func TestEmitJSON(t *testing.T) {
+	assert := assert.New(t)
+	var req exportRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		assert.Equal("key", r.Header.Get("Api-Key"))
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("%+v\n%s\n", err, body)
+		}
+	}))
+	defer srv.Close()
+
+	em, err := NewEmitter(srv.URL, WithEncoding(EncodingJSON), WithHeader("Api-Key", "key"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	reg := metrics.NewRegistry()
+	rep, err := exporters.NewReporter(reg, time.Hour).WithLabel("host", "node1").WithEmitter(em).Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	metrics.GetOrRegisterCounter("req", reg).Inc(3)
+	rep.Close()
+
+	assert.Equal([]keyValue{stringAttr("host", "node1")}, req.ResourceMetrics[0].Resource.Attributes)
+	m := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	assert.Equal("req", m.Name)
+	assert.Equal(int64(3), *m.Sum.DataPoints[0].AsInt)
+	assert.Nil(m.Sum.DataPoints[0].AsDouble)
+	assert.Nil(m.Sum.DataPoints[0].Attributes)
+}
+
+func TestEmitProtobuf(t *testing.T) {
+	assert := assert.New(t)
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("application/x-protobuf", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		// resource_metrics > scope_metrics > metrics > name
+		rm := field(t, body, 1)
+		sm := field(t, rm[0], 2)
+		for _, m := range field(t, sm[0], 2) {
+			names = append(names, string(field(t, m, 1)[0]))
+		}
+	}))
+	defer srv.Close()
+
+	em, err := NewEmitter(srv.URL)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if err := em.Emit(batch...); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal([]string{"req", "temp", "latency.m1", "latency"}, names)
+}
+
+// Get all length-delimited values of field num.
+func field(t *testing.T, buf []byte, num protowire.Number) [][]byte {
+	var vals [][]byte
+	for len(buf) > 0 {
+		n, typ, l := protowire.ConsumeTag(buf)
+		if l < 0 {
+			t.Fatalf("Invalid tag: %v", protowire.ParseError(l))
+		}
+		buf = buf[l:]
+		if n == num && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(buf)
+			vals = append(vals, v)
+		}
+		l = protowire.ConsumeFieldValue(n, typ, buf)
+		if l < 0 {
+			t.Fatalf("Invalid field: %v", protowire.ParseError(l))
+		}
+		buf = buf[l:]
+	}
+	return vals
+}
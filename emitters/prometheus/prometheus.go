@@ -0,0 +1,115 @@
+package prometheus
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	exporters "github.com/juvenn/metric-exporters"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Create a prometheus emitter, which caches metrics of last report and serves
+// them to prometheus scrapes. It is also a http.Handler, mount it to serve
+// scrapes, e.g.
+//
+//    prom := prometheus.NewEmitter()
+//    http.Handle("/metrics", prom)
+//    exporters.NewReporter(reg, 10*time.Second).WithEmitter(prom).Start()
+func NewEmitter(opts ...Option) *promEmitter {
+	em := &promEmitter{}
+	for _, opt := range opts {
+		opt(em)
+	}
+	return em
+}
+
+// Emit metrics as prometheus scrape endpoint, in text exposition format.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+type promEmitter struct {
+	format exporters.PromFormat
+
+	mu      sync.RWMutex
+	metrics []*exporters.Metric // last reported metrics
+}
+
+func (this *promEmitter) Name() string {
+	return "prometheus"
+}
+
+// Cache metrics to serve subsequent scrapes, it replaces last cached metrics.
+func (this *promEmitter) Emit(metrics ...*exporters.Metric) error {
+	batch := make([]*exporters.Metric, len(metrics))
+	copy(batch, metrics)
+	this.mu.Lock()
+	this.metrics = batch
+	this.mu.Unlock()
+	return nil
+}
+
+// Prometheus counters must be cumulative, thus delta mode is refused.
+func (this *promEmitter) SetDelta(delta bool) error {
+	if delta {
+		return errors.New("prometheus emitter does not support delta mode, as counters must be cumulative")
+	}
+	return nil
+}
+
+func (this *promEmitter) Close() error {
+	return nil
+}
+
+func (this *promEmitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	this.WriteTo(w)
+}
+
+// Write cached metrics to writer in text exposition format.
+func (this *promEmitter) WriteTo(w io.Writer) (int64, error) {
+	this.mu.RLock()
+	metrics := this.metrics
+	this.mu.RUnlock()
+
+	cw := &countWriter{w: w}
+	if err := this.format.WriteText(cw, metrics...); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+// Count bytes written and remember first error, so subsequent writes are
+// skipped.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+type Option func(*promEmitter)
+
+// Prefix each metric name with namespace, joined by underscore.
+func WithNamespace(ns string) Option {
+	return func(em *promEmitter) {
+		em.format.Namespace = ns
+	}
+}
+
+// Naming conventions and summary encoding, see exporters.PromFormat. It
+// replaces namespace set before.
+func WithFormat(f exporters.PromFormat) Option {
+	return func(em *promEmitter) {
+		em.format = f
+	}
+}
@@ -0,0 +1,89 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeScrape(t *testing.T) {
+	assert := assert.New(t)
+	prom := NewEmitter(WithNamespace("app"))
+	srv := httptest.NewServer(prom)
+	defer srv.Close()
+
+	prom.Emit(
+		&exporters.Metric{Name: "req.latency", Type: exporters.TypeTimer, Time: time.Unix(1667123357, 0),
+			Labels: map[string]string{"path": `/a"b`, "host-name": "node1"},
+			Fields: map[string]exporters.Value{"count": exporters.FloatValue(3), "p99": exporters.FloatValue(1.5)}},
+		&exporters.Metric{Name: "req.latency", Type: exporters.TypeTimer, Time: time.Unix(1667123357, 0),
+			Labels: map[string]string{"path": "/c\nd"},
+			Fields: map[string]exporters.Value{"count": exporters.FloatValue(1), "p99": exporters.FloatValue(2)}},
+		&exporters.Metric{Name: "http-200", Type: exporters.TypeGauge, Time: time.Unix(1667123357, 0),
+			Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(7)}},
+	)
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(contentType, resp.Header.Get("Content-Type"))
+	assert.Equal(`# HELP app_req_latency_count Field count of timer req.latency
+# TYPE app_req_latency_count counter
+app_req_latency_count{host_name="node1",path="/a\"b"} 3
+app_req_latency_count{path="/c\nd"} 1
+# HELP app_req_latency_p99 Field p99 of timer req.latency
+# TYPE app_req_latency_p99 gauge
+app_req_latency_p99{host_name="node1",path="/a\"b"} 1.5
+app_req_latency_p99{path="/c\nd"} 2
+# HELP app_http_200_gauge Field gauge of gauge http-200
+# TYPE app_http_200_gauge gauge
+app_http_200_gauge 7
+`, string(body))
+}
+
+func TestReportToScrape(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	prom := NewEmitter()
+	rep, err := exporters.NewReporter(reg, 100*time.Millisecond).WithEmitter(prom).Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer rep.Close()
+	metrics.GetOrRegisterCounter("req", reg).Inc(2)
+	time.Sleep(250 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	prom.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal("# HELP req_count Field count of counter req\n# TYPE req_count counter\nreq_count 2\n", rec.Body.String())
+}
+
+func TestRefuseDelta(t *testing.T) {
+	_, err := exporters.NewReporter(metrics.NewRegistry(), time.Second).
+		WithEmitter(NewEmitter()).
+		WithDelta(true).
+		Start()
+	assert.ErrorContains(t, err, "does not support delta mode")
+}
+
+func TestWithFormat(t *testing.T) {
+	assert := assert.New(t)
+	prom := NewEmitter(WithFormat(exporters.PromFormat{Namespace: "app", TotalSuffix: true}))
+	prom.Emit(&exporters.Metric{Name: "req", Type: exporters.TypeCounter,
+		Fields: map[string]exporters.Value{"count": exporters.IntValue(2)}})
+	var buf strings.Builder
+	n, err := prom.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal("# HELP app_req_total Field count of counter req\n# TYPE app_req_total counter\napp_req_total 2\n", buf.String())
+	assert.Equal(int64(buf.Len()), n)
+}
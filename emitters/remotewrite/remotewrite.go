@@ -0,0 +1,217 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	exporters "github.com/juvenn/metric-exporters"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Create an emitter to push metrics to prometheus remote write endpoint, such
+// as prometheus, mimir, victoria-metrics, e.g.
+//
+//    http://localhost:9090/api/v1/write
+func NewEmitter(writeUrl string, opts ...Option) (*remoteWriteEmitter, error) {
+	url, err := url.Parse(writeUrl)
+	if err != nil {
+		return nil, err
+	}
+	em := &remoteWriteEmitter{
+		writeUrl: url,
+		headers:  make(http.Header),
+		http: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+	for _, opt := range opts {
+		opt(em)
+	}
+	return em, nil
+}
+
+// Http based prometheus remote write emitter, each field of metric is written
+// as one time series.
+// See https://prometheus.io/docs/concepts/remote_write_spec/
+type remoteWriteEmitter struct {
+	writeUrl    *url.URL
+	username    string
+	password    string
+	bearerToken string
+	headers     http.Header // extra headers, such as X-Scope-OrgID
+
+	http *http.Client
+	sent int64 // bytes sent
+}
+
+func (this *remoteWriteEmitter) Name() string {
+	return fmt.Sprintf("remote-write: %s", this.writeUrl.String())
+}
+
+func (this *remoteWriteEmitter) BytesSent() int64 {
+	return atomic.LoadInt64(&this.sent)
+}
+
+// Prometheus counters must be cumulative, thus delta mode is refused.
+func (this *remoteWriteEmitter) SetDelta(delta bool) error {
+	if delta {
+		return errors.New("remote write emitter does not support delta mode, as counters must be cumulative")
+	}
+	return nil
+}
+
+func (this *remoteWriteEmitter) Close() error {
+	return nil
+}
+
+func (this *remoteWriteEmitter) Emit(metrics ...*exporters.Metric) error {
+	return this.EmitContext(context.Background(), metrics...)
+}
+
+func (this *remoteWriteEmitter) EmitContext(ctx context.Context, metrics ...*exporters.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, EncodeWriteRequest(metrics...))
+	return this.request(ctx, body)
+}
+
+func (this *remoteWriteEmitter) request(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, this.writeUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range this.headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if this.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+this.bearerToken)
+	} else if this.username != "" && this.password != "" {
+		req.SetBasicAuth(this.username, this.password)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "metrics-exporter/0.1.0")
+	resp, err := this.http.Do(req)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&this.sent, int64(len(body)))
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		bstr, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s %s %s", http.MethodPost, this.writeUrl, resp.Status, string(bstr))
+	}
+	return nil
+}
+
+// Encode metrics as uncompressed remote write protobuf message, each numeric
+// field of metric is encoded as one time series, named `name_field` and sorted
+// labels.
+//
+//    message WriteRequest { repeated TimeSeries timeseries = 1; }
+//    message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//    message Label { string name = 1; string value = 2; }
+//    message Sample { double value = 1; int64 timestamp = 2; }
+func EncodeWriteRequest(metrics ...*exporters.Metric) []byte {
+	var buf, series []byte
+	for _, metric := range metrics {
+		ts := metric.Time.UnixMilli()
+		if metric.Time.IsZero() {
+			ts = time.Now().UnixMilli()
+		}
+		labels := make([]label, 0, len(metric.Labels)+1)
+		for k, v := range metric.Labels {
+			if v == "" {
+				continue
+			}
+			labels = append(labels, label{exporters.PromLabelName(k), v})
+		}
+		labels = append(labels, label{"__name__", ""})
+		for _, entry := range exporters.SortByKey(metric.Fields) {
+			if !entry.Val.IsNumeric() {
+				continue
+			}
+			labels[len(labels)-1].value = exporters.PromMetricName(metric.Name + "_" + entry.Key)
+			series = appendTimeSeries(series[:0], labels, entry.Val.Float(), ts)
+			buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+			buf = protowire.AppendBytes(buf, series)
+		}
+	}
+	return buf
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+func appendTimeSeries(buf []byte, labels []label, value float64, ts int64) []byte {
+	sorted := make([]label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+	var msg []byte
+	for _, l := range sorted {
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, l.name)
+		msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+		msg = protowire.AppendString(msg, l.value)
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, msg)
+	}
+	msg = msg[:0]
+	msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+	msg = protowire.AppendFixed64(msg, math.Float64bits(value))
+	msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(ts))
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, msg)
+	return buf
+}
+
+type Option func(*remoteWriteEmitter)
+
+// Http request timeout, default to 5s.
+func WithRequestTimeout(du time.Duration) Option {
+	return func(em *remoteWriteEmitter) {
+		em.http.Timeout = du
+	}
+}
+
+// Basic authentication
+func WithBasicAuth(user, pass string) Option {
+	return func(em *remoteWriteEmitter) {
+		em.username = user
+		em.password = pass
+	}
+}
+
+// Bearer token authentication, takes precedence over basic auth.
+func WithBearerToken(token string) Option {
+	return func(em *remoteWriteEmitter) {
+		em.bearerToken = token
+	}
+}
+
+// Add extra header to each request, e.g. X-Scope-OrgID for mimir tenant.
+func WithHeader(k, v string) Option {
+	return func(em *remoteWriteEmitter) {
+		em.headers.Add(k, v)
+	}
+}
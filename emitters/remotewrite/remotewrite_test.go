@@ -0,0 +1,141 @@
+package remotewrite
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type timeSeries struct {
+	labels  map[string]string
+	value   float64
+	time    int64
+	nlabels []string // label names in order
+}
+
+// Decode write request, assuming one sample per series.
+func decodeWriteRequest(t *testing.T, buf []byte) []timeSeries {
+	var series []timeSeries
+	each(t, buf, func(num protowire.Number, val []byte, _ uint64) {
+		ts := timeSeries{labels: make(map[string]string)}
+		each(t, val, func(num protowire.Number, val []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, value string
+				each(t, val, func(num protowire.Number, val []byte, _ uint64) {
+					if num == 1 {
+						name = string(val)
+					} else {
+						value = string(val)
+					}
+				})
+				ts.labels[name] = value
+				ts.nlabels = append(ts.nlabels, name)
+			case 2:
+				each(t, val, func(num protowire.Number, _ []byte, n uint64) {
+					if num == 1 {
+						ts.value = math.Float64frombits(n)
+					} else {
+						ts.time = int64(n)
+					}
+				})
+			}
+		})
+		series = append(series, ts)
+	})
+	return series
+}
+
+func each(t *testing.T, buf []byte, fn func(protowire.Number, []byte, uint64)) {
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			t.Fatalf("Invalid tag: %v", protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(buf)
+			fn(num, v, 0)
+			buf = buf[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(buf)
+			fn(num, nil, v)
+			buf = buf[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(buf)
+			fn(num, nil, v)
+			buf = buf[n:]
+		default:
+			t.Fatalf("Unexpected wire type %d", typ)
+		}
+	}
+}
+
+func TestEmit(t *testing.T) {
+	assert := assert.New(t)
+	var series []timeSeries
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal("snappy", req.Header.Get("Content-Encoding"))
+		assert.Equal("application/x-protobuf", req.Header.Get("Content-Type"))
+		assert.Equal("0.1.0", req.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal("Bearer secret", req.Header.Get("Authorization"))
+		assert.Equal("tenant1", req.Header.Get("X-Scope-OrgID"))
+		compressed, _ := ioutil.ReadAll(req.Body)
+		buf, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		series = decodeWriteRequest(t, buf)
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	em, err := NewEmitter(srv.URL+"/api/v1/write", WithBearerToken("secret"), WithHeader("X-Scope-OrgID", "tenant1"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	err = em.Emit(&exporters.Metric{
+		Name: "req.latency", Type: exporters.TypeTimer, Time: time.UnixMilli(1667123357123),
+		Labels: map[string]string{"host": "node1", "Zone": "a"},
+		Fields: map[string]exporters.Value{"count": exporters.FloatValue(3), "p99": exporters.FloatValue(1.5)},
+	})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Len(series, 2)
+	assert.Equal(map[string]string{"__name__": "req_latency_count", "host": "node1", "Zone": "a"}, series[0].labels)
+	assert.Equal([]string{"Zone", "__name__", "host"}, series[0].nlabels)
+	assert.Equal(3.0, series[0].value)
+	assert.Equal(int64(1667123357123), series[0].time)
+	assert.Equal("req_latency_p99", series[1].labels["__name__"])
+	assert.Equal(1.5, series[1].value)
+}
+
+func TestEmitError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte("out of order sample"))
+	}))
+	defer srv.Close()
+	em, _ := NewEmitter(srv.URL)
+	err := em.Emit(&exporters.Metric{Name: "req", Fields: map[string]exporters.Value{"count": exporters.FloatValue(1)}})
+	assert.ErrorContains(t, err, "out of order sample")
+}
+
+func TestRefuseDelta(t *testing.T) {
+	em, err := NewEmitter("http://localhost:9090/api/v1/write")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Nil(t, em.SetDelta(false))
+	assert.ErrorContains(t, em.SetDelta(true), "does not support delta mode")
+}
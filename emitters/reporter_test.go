@@ -63,7 +63,7 @@ func TestReportToFile(t *testing.T) {
 			t.Errorf("Labels.host localhost != %s\n", host)
 		}
 	}
-	if val := data[1].Fields["count"]; val != 2 {
-		t.Errorf("Counter should be reset and incremented, but got %f\n", val)
+	if val := data[1].Fields["count"]; val != exporters.IntValue(2) {
+		t.Errorf("Counter should be reset and incremented, but got %s\n", val)
 	}
 }
@@ -0,0 +1,346 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+)
+
+const segmentExt = ".spool"
+
+// Wrap an emitter with a disk-backed spool in dir, so that batches failed to
+// emit are persisted, and replayed in order once upstream recovers, even
+// after process restarts. This gives at-least-once delivery, e.g.
+//
+//    inf, _ := influx.NewV2Emitter(influxUrl, "bucket")
+//    em, err := spool.NewEmitter("/var/spool/metrics", inf)
+//
+// Batches are appended to segment files as json lines, segment is rolled by
+// size or age, and oldest segments are dropped when spool exceeds max size or
+// max age.
+func NewEmitter(dir string, em exporters.Emitter, opts ...Option) (*spoolEmitter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	sp := &spoolEmitter{
+		EmitterWrapper: exporters.EmitterWrapper{Emitter: em},
+		dir:            dir,
+		segmentSize:    4 << 20,
+		segmentAge:     10 * time.Minute,
+		maxSize:        256 << 20,
+		maxAge:         24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(sp)
+	}
+	if err := sp.load(); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+type spoolEmitter struct {
+	exporters.EmitterWrapper
+	dir         string
+	segmentSize int64         // roll segment when exceeds size
+	segmentAge  time.Duration // roll segment when exceeds age
+	maxSize     int64         // drop oldest segments when spool exceeds size
+	maxAge      time.Duration // drop segments older than, 0 means no limit
+
+	mu       sync.Mutex
+	segments []*segment          // oldest first
+	active   *os.File            // last segment opened for appending
+	guard    exporters.EmitGuard // emit under deadline even if emitter is not a ContextEmitter
+}
+
+// A segment file is named by its creation time in unix nanoseconds, so that
+// segments sort in creation order.
+type segment struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+func (this *spoolEmitter) Name() string {
+	return fmt.Sprintf("%s (spool: %s)", this.Emitter.Name(), this.dir)
+}
+
+func (this *spoolEmitter) Close() error {
+	this.mu.Lock()
+	this.closeActive()
+	this.mu.Unlock()
+	return this.Emitter.Close()
+}
+
+func (this *spoolEmitter) Emit(metrics ...*exporters.Metric) error {
+	return this.EmitContext(context.Background(), metrics...)
+}
+
+// Replay spooled batches in order, then emit metrics. If any fails, metrics
+// are spooled after those remaining.
+func (this *spoolEmitter) EmitContext(ctx context.Context, metrics ...*exporters.Metric) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	err := this.replay(ctx)
+	if err == nil {
+		err = this.emit(ctx, metrics)
+		if err == nil {
+			return nil
+		}
+	}
+	if len(metrics) == 0 {
+		return err
+	}
+	if serr := this.spool(metrics); serr != nil {
+		return fmt.Errorf("Failed to spool %d metric points: %s, upstream error: %w", len(metrics), serr, err)
+	}
+	return fmt.Errorf("Spooled %d metric points, upstream error: %w", len(metrics), err)
+}
+
+// Number of bytes currently spooled.
+func (this *spoolEmitter) Size() int64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	var size int64
+	for _, seg := range this.segments {
+		size += seg.size
+	}
+	return size
+}
+
+func (this *spoolEmitter) emit(ctx context.Context, metrics []*exporters.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	return this.guard.Emit(ctx, this.Emitter, metrics...)
+}
+
+// Load segments left in dir, e.g. by last process.
+func (this *spoolEmitter) load() error {
+	entries, err := ioutil.ReadDir(this.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		this.segments = append(this.segments, &segment{
+			path:    filepath.Join(this.dir, name),
+			size:    entry.Size(),
+			created: time.Unix(0, nanos),
+		})
+	}
+	sort.Slice(this.segments, func(i, j int) bool {
+		return this.segments[i].created.Before(this.segments[j].created)
+	})
+	return nil
+}
+
+// Replay segments in order, each segment is removed once all its batches are
+// emitted. On failure the segment is rewritten with remaining batches.
+func (this *spoolEmitter) replay(ctx context.Context) error {
+	this.expire()
+	for len(this.segments) > 0 {
+		seg := this.segments[0]
+		if len(this.segments) == 1 {
+			this.closeActive()
+		}
+		lines, err := readLines(seg.path)
+		if err != nil {
+			return err
+		}
+		for i, line := range lines {
+			var batch []*exporters.Metric
+			if err := json.Unmarshal(line, &batch); err != nil {
+				// skip corrupted batch, e.g. partially written on crash
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return this.rewrite(seg, lines[i:], err)
+			}
+			if err := this.emit(ctx, batch); err != nil {
+				return this.rewrite(seg, lines[i:], err)
+			}
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		this.segments = this.segments[1:]
+	}
+	return nil
+}
+
+// Rewrite segment with remaining lines atomically, and return cause.
+func (this *spoolEmitter) rewrite(seg *segment, lines [][]byte, cause error) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := seg.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return cause
+	}
+	if err := os.Rename(tmp, seg.path); err != nil {
+		os.Remove(tmp)
+		return cause
+	}
+	seg.size = int64(buf.Len())
+	return cause
+}
+
+// Append batch to active segment as one json line, then drop oldest segments
+// if spool exceeds max size.
+func (this *spoolEmitter) spool(metrics []*exporters.Metric) error {
+	line, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if err := this.roll(); err != nil {
+		return err
+	}
+	seg := this.segments[len(this.segments)-1]
+	n, err := this.active.Write(line)
+	seg.size += int64(n)
+	if err != nil {
+		this.closeActive()
+		return err
+	}
+	if err := this.active.Sync(); err != nil {
+		return err
+	}
+	this.trim()
+	return nil
+}
+
+// Open a new active segment, if there is none, or it exceeds size or age.
+func (this *spoolEmitter) roll() error {
+	if this.active != nil {
+		seg := this.segments[len(this.segments)-1]
+		if seg.size < this.segmentSize && time.Since(seg.created) < this.segmentAge {
+			return nil
+		}
+		this.closeActive()
+	}
+	now := time.Now()
+	if n := len(this.segments); n > 0 && !now.After(this.segments[n-1].created) {
+		now = this.segments[n-1].created.Add(1)
+	}
+	seg := &segment{
+		path:    filepath.Join(this.dir, strconv.FormatInt(now.UnixNano(), 10)+segmentExt),
+		created: now,
+	}
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	this.active = file
+	this.segments = append(this.segments, seg)
+	return nil
+}
+
+func (this *spoolEmitter) closeActive() {
+	if this.active != nil {
+		this.active.Close()
+		this.active = nil
+	}
+}
+
+// Drop oldest segments while spool exceeds max size, keeping at least the
+// newest one.
+func (this *spoolEmitter) trim() {
+	var size int64
+	for _, seg := range this.segments {
+		size += seg.size
+	}
+	for size > this.maxSize && len(this.segments) > 1 {
+		seg := this.segments[0]
+		os.Remove(seg.path)
+		size -= seg.size
+		this.segments = this.segments[1:]
+	}
+}
+
+// Drop segments older than max age.
+func (this *spoolEmitter) expire() {
+	if this.maxAge <= 0 {
+		return
+	}
+	for len(this.segments) > 0 && time.Since(this.segments[0].created) > this.maxAge {
+		if len(this.segments) == 1 {
+			this.closeActive()
+		}
+		os.Remove(this.segments[0].path)
+		this.segments = this.segments[1:]
+	}
+}
+
+func readLines(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines [][]byte
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			lines = append(lines, line[:len(line)-1])
+		}
+		if err != nil {
+			// partial line without newline is dropped
+			break
+		}
+	}
+	return lines, nil
+}
+
+type Option func(*spoolEmitter)
+
+// Roll to a new segment when active one exceeds size, default to 4MB.
+func WithSegmentSize(size int64) Option {
+	return func(sp *spoolEmitter) {
+		sp.segmentSize = size
+	}
+}
+
+// Roll to a new segment when active one exceeds age, default to 10m.
+func WithSegmentAge(du time.Duration) Option {
+	return func(sp *spoolEmitter) {
+		sp.segmentAge = du
+	}
+}
+
+// Drop oldest segments when spool exceeds size, default to 256MB.
+func WithMaxSize(size int64) Option {
+	return func(sp *spoolEmitter) {
+		sp.maxSize = size
+	}
+}
+
+// Drop segments older than age, default to 24h, 0 means no limit.
+func WithMaxAge(du time.Duration) Option {
+	return func(sp *spoolEmitter) {
+		sp.maxAge = du
+	}
+}
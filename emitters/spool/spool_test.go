@@ -0,0 +1,165 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/stretchr/testify/assert"
+)
+
+// An upstream that fails when down, and records names of emitted metrics.
+type upstream struct {
+	down  bool
+	names []string
+}
+
+func (up *upstream) Name() string { return "upstream" }
+
+func (up *upstream) Close() error { return nil }
+
+func (up *upstream) Emit(metrics ...*exporters.Metric) error {
+	if up.down {
+		return errors.New("upstream is down")
+	}
+	for _, m := range metrics {
+		up.names = append(up.names, m.Name)
+	}
+	return nil
+}
+
+func batch(names ...string) []*exporters.Metric {
+	metrics := make([]*exporters.Metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, &exporters.Metric{
+			Name: name, Type: exporters.TypeCounter, Time: time.Unix(1667123357, 0),
+			Fields: map[string]exporters.Value{"count": exporters.FloatValue(1)},
+		})
+	}
+	return metrics
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	up := &upstream{down: true}
+	em, err := NewEmitter(dir, up, WithSegmentSize(50))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.ErrorContains(em.Emit(batch("a1", "a2")...), "upstream is down")
+	assert.ErrorContains(em.Emit(batch("b1")...), "Spooled 1 metric points")
+	assert.Error(em.Emit(batch("c1")...))
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(files, 3, "Should roll segment by size")
+	assert.Nil(em.Close())
+	assert.Empty(up.names)
+
+	// restart with upstream recovered
+	up.down = false
+	em, err = NewEmitter(dir, up)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	assert.Nil(em.Emit(batch("d1")...))
+	assert.Equal([]string{"a1", "a2", "b1", "c1", "d1"}, up.names)
+	assert.Equal(int64(0), em.Size())
+	files, _ = ioutil.ReadDir(dir)
+	assert.Len(files, 0)
+}
+
+// An upstream which fails after n successful emits.
+type flakyUpstream struct {
+	upstream
+	n int
+}
+
+func (up *flakyUpstream) Emit(metrics ...*exporters.Metric) error {
+	if up.n <= 0 {
+		return fmt.Errorf("flaky")
+	}
+	up.n--
+	return up.upstream.Emit(metrics...)
+}
+
+func TestPartialReplay(t *testing.T) {
+	assert := assert.New(t)
+	up := &flakyUpstream{}
+	em, err := NewEmitter(t.TempDir(), up)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	em.Emit(batch("a")...)
+	em.Emit(batch("b")...)
+	em.Emit(batch("c")...)
+	// replay a, then fail on b, d is spooled behind c
+	up.n = 1
+	assert.Error(em.Emit(batch("d")...))
+	assert.Equal([]string{"a"}, up.names)
+	up.n = 10
+	assert.Nil(em.Emit(batch("e")...))
+	assert.Equal([]string{"a", "b", "c", "d", "e"}, up.names)
+}
+
+func TestMaxSize(t *testing.T) {
+	assert := assert.New(t)
+	up := &upstream{down: true}
+	em, err := NewEmitter(t.TempDir(), up, WithSegmentSize(1), WithMaxSize(200))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	for i := 0; i < 5; i++ {
+		em.Emit(batch(fmt.Sprintf("m%d", i))...)
+	}
+	assert.LessOrEqual(em.Size(), int64(200))
+	up.down = false
+	em.Emit()
+	assert.Equal([]string{"m3", "m4"}, up.names)
+}
+
+// An upstream which hangs until gate is closed, and records resource.
+type hungUpstream struct {
+	upstream
+	gate     chan struct{}
+	resource map[string]string
+}
+
+func (up *hungUpstream) Emit(metrics ...*exporters.Metric) error {
+	<-up.gate
+	return up.upstream.Emit(metrics...)
+}
+
+func (up *hungUpstream) SetResource(labels map[string]string) {
+	up.resource = labels
+}
+
+func TestEmitDeadline(t *testing.T) {
+	assert := assert.New(t)
+	up := &hungUpstream{gate: make(chan struct{})}
+	em, err := NewEmitter(t.TempDir(), up)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	em.SetResource(map[string]string{"host": "node1"})
+	assert.Equal(map[string]string{"host": "node1"}, up.resource)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = em.EmitContext(ctx, batch("a")...)
+	assert.Less(time.Since(start), time.Second)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Greater(em.Size(), int64(0), "Should spool batch")
+
+	close(up.gate)
+	assert.Nil(em.Emit(batch("b")...))
+	assert.Equal(int64(0), em.Size())
+}
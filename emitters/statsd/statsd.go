@@ -0,0 +1,200 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	exporters "github.com/juvenn/metric-exporters"
+)
+
+// Create an emitter to send metrics to statsd agent over udp, e.g.
+// 127.0.0.1:8125. Metrics are mapped as:
+//
+//    counter            => name:count|c
+//    gauge              => name:value|g
+//    meter, histogram   => name.field:value|g
+//    timer              => name.field:value|g, or name.field:value|ms with timings
+//
+// NOTE that statsd counters are deltas, thus reporter should auto remove
+// counters, or report deltas, otherwise cumulative counts are sent. String
+// fields are skipped.
+func NewEmitter(addr string, opts ...Option) (*statsdEmitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	em := &statsdEmitter{
+		addr: addr,
+		mtu:  1432,
+		conn: conn,
+	}
+	for _, opt := range opts {
+		opt(em)
+	}
+	return em, nil
+}
+
+// Udp based statsd emitter, which packs multiple lines into one datagram.
+type statsdEmitter struct {
+	addr    string
+	prefix  string // prefix to each metric name
+	mtu     int    // max datagram size
+	tags    bool   // encode labels as dogstatsd tags
+	timings bool   // send timer durations as timings in milliseconds
+
+	conn net.Conn
+	sent int64 // bytes sent
+}
+
+func (this *statsdEmitter) Name() string {
+	return fmt.Sprintf("statsd: %s", this.addr)
+}
+
+func (this *statsdEmitter) BytesSent() int64 {
+	return atomic.LoadInt64(&this.sent)
+}
+
+func (this *statsdEmitter) Close() error {
+	return this.conn.Close()
+}
+
+func (this *statsdEmitter) Emit(metrics ...*exporters.Metric) error {
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		n, err := this.conn.Write(buf.Bytes())
+		atomic.AddInt64(&this.sent, int64(n))
+		buf.Reset()
+		return err
+	}
+	for _, metric := range metrics {
+		for _, line := range this.encode(metric) {
+			if buf.Len() > 0 && buf.Len()+1+len(line) > this.mtu {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(line)
+		}
+	}
+	return flush()
+}
+
+// Encode metric as statsd lines, non-finite values are dropped.
+func (this *statsdEmitter) encode(metric *exporters.Metric) []string {
+	name := sanitize(metric.Name)
+	if this.prefix != "" {
+		name = this.prefix + "." + name
+	}
+	tags := ""
+	if this.tags {
+		tags = encodeTags(metric.Labels)
+	}
+	lines := make([]string, 0, len(metric.Fields))
+	for _, entry := range exporters.SortByKey(metric.Fields) {
+		f, v := entry.Key, entry.Val.Float()
+		if !entry.Val.IsNumeric() || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		switch {
+		case metric.Type == exporters.TypeCounter:
+			lines = append(lines, name+":"+formatValue(v)+"|c"+tags)
+		case metric.Type == exporters.TypeGauge:
+			lines = appendGauge(lines, name, v, tags)
+		case metric.Type == exporters.TypeTimer && this.timings && exporters.IsTimerDuration(f):
+			ms := v / float64(1e6)
+			lines = append(lines, name+"."+sanitize(f)+":"+formatValue(ms)+"|ms"+tags)
+		default:
+			lines = appendGauge(lines, name+"."+sanitize(f), v, tags)
+		}
+	}
+	return lines
+}
+
+// A gauge with leading sign is regarded as delta by statsd, thus negative
+// value is sent by resetting gauge to zero first.
+func appendGauge(lines []string, name string, v float64, tags string) []string {
+	if v < 0 {
+		lines = append(lines, name+":0|g"+tags)
+	}
+	return append(lines, name+":"+formatValue(v)+"|g"+tags)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var (
+	nameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
+	tagReplacer  = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", " ", "_", "\n", "_")
+	// tag value may contain colon
+	tagValueReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", " ", "_", "\n", "_")
+)
+
+func sanitize(name string) string {
+	return nameReplacer.Replace(name)
+}
+
+// Encode labels as dogstatsd tags, e.g. `|#host:node1,region:us-west-2`.
+// Labels with empty value are dropped.
+func encodeTags(labels map[string]string) string {
+	var sb strings.Builder
+	for _, entry := range exporters.SortByKey(labels) {
+		if entry.Key == "" || entry.Val == "" {
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("|#")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(tagReplacer.Replace(entry.Key))
+		sb.WriteString(":")
+		sb.WriteString(tagValueReplacer.Replace(entry.Val))
+	}
+	return sb.String()
+}
+
+type Option func(*statsdEmitter)
+
+// Prefix to each metric name, joined by dot.
+func WithPrefix(prefix string) Option {
+	return func(em *statsdEmitter) {
+		em.prefix = prefix
+	}
+}
+
+// Max size of udp datagram, default to 1432, which fits in ethernet mtu.
+// Lines are packed into datagrams up to this size, a line larger than it is
+// sent alone.
+func WithMTU(mtu int) Option {
+	return func(em *statsdEmitter) {
+		em.mtu = mtu
+	}
+}
+
+// Encode labels as dogstatsd tags, default to false, where labels are dropped.
+func WithTags(b bool) Option {
+	return func(em *statsdEmitter) {
+		em.tags = b
+	}
+}
+
+// Send timer durations (min, max, mean, stddev and percentiles) as timings
+// `|ms` in milliseconds, default to false, where they are sent as gauges in
+// nanoseconds.
+func WithTimings(b bool) Option {
+	return func(em *statsdEmitter) {
+		em.timings = b
+	}
+}
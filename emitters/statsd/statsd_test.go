@@ -0,0 +1,82 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	exporters "github.com/juvenn/metric-exporters"
+	"github.com/stretchr/testify/assert"
+)
+
+func listen(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	return conn
+}
+
+func receive(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	return string(buf[:n])
+}
+
+func TestEmit(t *testing.T) {
+	assert := assert.New(t)
+	srv := listen(t)
+	defer srv.Close()
+	em, err := NewEmitter(srv.LocalAddr().String(), WithPrefix("app"), WithTags(true), WithTimings(true))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	labels := map[string]string{"host": "node1", "url": "http://a|b"}
+	err = em.Emit(
+		&exporters.Metric{Name: "req", Type: exporters.TypeCounter, Labels: labels,
+			Fields: map[string]exporters.Value{"count": exporters.FloatValue(3)}},
+		&exporters.Metric{Name: "temp:c", Type: exporters.TypeGauge,
+			Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(-2.5)}},
+		&exporters.Metric{Name: "latency", Type: exporters.TypeTimer,
+			Fields: map[string]exporters.Value{"count": exporters.FloatValue(2), "p99": exporters.FloatValue(1.5e6), "m1": exporters.FloatValue(0.2)}},
+	)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(strings.Join([]string{
+		"app.req:3|c|#host:node1,url:http://a_b",
+		"app.temp_c:0|g",
+		"app.temp_c:-2.5|g",
+		"app.latency.count:2|g",
+		"app.latency.m1:0.2|g",
+		"app.latency.p99:1.5|ms",
+	}, "\n"), receive(t, srv))
+}
+
+func TestPackMTU(t *testing.T) {
+	assert := assert.New(t)
+	srv := listen(t)
+	defer srv.Close()
+	em, err := NewEmitter(srv.LocalAddr().String(), WithMTU(20))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer em.Close()
+	err = em.Emit(
+		&exporters.Metric{Name: "a", Type: exporters.TypeGauge, Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(1)}},
+		&exporters.Metric{Name: "b", Type: exporters.TypeGauge, Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(2)}},
+		&exporters.Metric{Name: "c", Type: exporters.TypeGauge, Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(3)}},
+		&exporters.Metric{Name: "a.very.long.metric.name", Type: exporters.TypeGauge, Fields: map[string]exporters.Value{"gauge": exporters.FloatValue(4)}},
+	)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal("a:1|g\nb:2|g\nc:3|g", receive(t, srv))
+	assert.Equal("a.very.long.metric.name:4|g", receive(t, srv))
+}
@@ -0,0 +1,268 @@
+package exporters
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Encoder encodes batches of metrics to stream, such as file or http body.
+// Encoder may keep state across batches, e.g. csv header, thus it should not
+// be shared by streams.
+type Encoder interface {
+	// Encode one batch of metrics to writer.
+	Encode(w io.Writer, metrics ...*Metric) error
+	// Mime type of encoded stream, e.g. application/json.
+	ContentType() string
+}
+
+// An encoder may also implement HeaderEncoder if it writes header before first
+// batch, e.g. csv, so that header is not written again when appending to a
+// non-empty stream.
+type HeaderEncoder interface {
+	Encoder
+	// Regard header as written, it is not written before next batch.
+	SkipHeader()
+}
+
+// An encoder may also implement SingleBatchEncoder if its output is valid for
+// one batch only, e.g. prometheus scrape, thus batches can not be appended to
+// one stream, such as file.
+type SingleBatchEncoder interface {
+	Encoder
+	// Mark encoder as single batch only.
+	SingleBatch()
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]func() Encoder{
+		"json":        func() Encoder { return NewJSONEncoder() },
+		"influx":      func() Encoder { return NewInfluxEncoder("ns") },
+		"prometheus":  func() Encoder { return NewPromEncoder(PromFormat{}) },
+		"openmetrics": func() Encoder { return NewOpenMetricsEncoder(PromFormat{}) },
+		"csv":         func() Encoder { return NewCSVEncoder() },
+		"logfmt":      func() Encoder { return NewLogfmtEncoder() },
+	}
+)
+
+// Register encoder by format name, it replaces encoder of same name. Builtin
+// formats are json, influx, prometheus, openmetrics, csv and logfmt.
+func RegisterEncoder(format string, fn func() Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[format] = fn
+}
+
+// Create encoder of format name, see RegisterEncoder.
+func NewEncoder(format string) (Encoder, error) {
+	encodersMu.RLock()
+	fn, ok := encoders[format]
+	encodersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Encoder format must be one of [%s]", strings.Join(EncoderFormats(), ","))
+	}
+	return fn(), nil
+}
+
+// Names of registered encoder formats, sorted.
+func EncoderFormats() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	formats := make([]string, 0, len(encoders))
+	for format := range encoders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Encode each metric as one json line.
+func NewJSONEncoder() Encoder {
+	return jsonEncoder{}
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (jsonEncoder) Encode(w io.Writer, metrics ...*Metric) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, metric := range metrics {
+		if err := enc.Encode(metric); err != nil {
+			bw.Flush()
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Encode each metric as one line of influx line protocol, with timestamp in
+// precision of [ns,u,us,ms,s].
+func NewInfluxEncoder(precision string) Encoder {
+	return influxEncoder{precision}
+}
+
+type influxEncoder struct {
+	precision string
+}
+
+func (influxEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+func (enc influxEncoder) Encode(w io.Writer, metrics ...*Metric) error {
+	bw := bufio.NewWriter(w)
+	for _, metric := range metrics {
+		if line := metric.EncodeInfluxLine(enc.precision); line != "" {
+			bw.WriteString(line)
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// Encode metrics in prometheus text exposition format, see PromFormat. It is
+// single batch only, as HELP and TYPE are repeated for each batch.
+func NewPromEncoder(f PromFormat) Encoder {
+	return promEncoder{f}
+}
+
+type promEncoder struct {
+	format PromFormat
+}
+
+func (promEncoder) SingleBatch() {}
+
+func (promEncoder) ContentType() string {
+	return "text/plain; version=0.0.4; charset=utf-8"
+}
+
+func (enc promEncoder) Encode(w io.Writer, metrics ...*Metric) error {
+	return enc.format.WriteText(w, metrics...)
+}
+
+// Encode metrics in OpenMetrics text format, each batch is terminated by
+// # EOF, see PromFormat. It is single batch only.
+func NewOpenMetricsEncoder(f PromFormat) Encoder {
+	return openMetricsEncoder{f}
+}
+
+type openMetricsEncoder struct {
+	format PromFormat
+}
+
+func (openMetricsEncoder) SingleBatch() {}
+
+func (openMetricsEncoder) ContentType() string {
+	return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+}
+
+func (enc openMetricsEncoder) Encode(w io.Writer, metrics ...*Metric) error {
+	return enc.format.WriteOpenMetrics(w, metrics...)
+}
+
+// Encode each field of metric as one csv record, with header written before
+// first batch:
+//
+//    time,name,type,labels,field,value
+//    2022-10-30T09:49:17Z,req,counter,host=node1;region=us,count,5
+//
+// Labels are sorted and joined by semicolon.
+func NewCSVEncoder() Encoder {
+	return &csvEncoder{}
+}
+
+type csvEncoder struct {
+	wroteHeader bool
+}
+
+func (enc *csvEncoder) SkipHeader() {
+	enc.wroteHeader = true
+}
+
+func (*csvEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (enc *csvEncoder) Encode(w io.Writer, metrics ...*Metric) error {
+	cw := csv.NewWriter(w)
+	if !enc.wroteHeader {
+		cw.Write([]string{"time", "name", "type", "labels", "field", "value"})
+		enc.wroteHeader = true
+	}
+	for _, metric := range metrics {
+		ts := metric.Time.UTC().Format(time.RFC3339Nano)
+		labels := make([]string, 0, len(metric.Labels))
+		for _, entry := range SortByKey(metric.Labels) {
+			labels = append(labels, entry.Key+"="+entry.Val)
+		}
+		for _, entry := range SortByKey(metric.Fields) {
+			cw.Write([]string{ts, metric.Name, string(metric.Type), strings.Join(labels, ";"), entry.Key, entry.Val.String()})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Encode each metric as one logfmt line, with time, name and type, followed
+// by sorted labels and fields, e.g.
+//
+//    time=2022-10-30T09:49:17Z name=req type=counter host=node1 count=5
+func NewLogfmtEncoder() Encoder {
+	return logfmtEncoder{}
+}
+
+type logfmtEncoder struct{}
+
+func (logfmtEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+func (logfmtEncoder) Encode(w io.Writer, metrics ...*Metric) error {
+	bw := bufio.NewWriter(w)
+	for _, metric := range metrics {
+		bw.WriteString("time=" + metric.Time.UTC().Format(time.RFC3339Nano))
+		writeLogfmt(bw, "name", metric.Name)
+		writeLogfmt(bw, "type", string(metric.Type))
+		for _, entry := range SortByKey(metric.Labels) {
+			writeLogfmt(bw, entry.Key, entry.Val)
+		}
+		for _, entry := range SortByKey(metric.Fields) {
+			writeLogfmt(bw, entry.Key, entry.Val.String())
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+func writeLogfmt(w *bufio.Writer, k, v string) {
+	w.WriteString(" ")
+	w.WriteString(logfmtValue(k))
+	w.WriteString("=")
+	w.WriteString(logfmtValue(v))
+}
+
+// Quote value if it is empty or contains space, equals sign, quote or control
+// chars.
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	for _, c := range v {
+		if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
@@ -0,0 +1,92 @@
+package exporters
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncoders(t *testing.T) {
+	assert := assert.New(t)
+	metrics := []*Metric{
+		{Name: "req", Type: TypeCounter, Time: time.Unix(1667123357, 0),
+			Labels: map[string]string{"host": "node1", "path": "/a b"},
+			Fields: map[string]Value{"count": IntValue(5)}},
+		{Name: "job", Type: TypeGauge, Time: time.Unix(1667123357, 0),
+			Fields: map[string]Value{"gauge": FloatValue(1.5), "status": StringValue("done")}},
+	}
+	cases := map[string]string{
+		"json": `{"name":"req","type":"counter","time":"` + time.Unix(1667123357, 0).Format(time.RFC3339Nano) + `","labels":{"host":"node1","path":"/a b"},"fields":{"count":5}}
+{"name":"job","type":"gauge","time":"` + time.Unix(1667123357, 0).Format(time.RFC3339Nano) + `","fields":{"gauge":1.5,"status":"done"}}
+`,
+		"influx": `req,host=node1,path=/a\ b count=5i 1667123357000000000
+job gauge=1.5,status="done" 1667123357000000000
+`,
+		"prometheus": `# HELP req_count Field count of counter req
+# TYPE req_count counter
+req_count{host="node1",path="/a b"} 5
+# HELP job_gauge Field gauge of gauge job
+# TYPE job_gauge gauge
+job_gauge 1.5
+`,
+		"openmetrics": `# HELP req_count Field count of counter req
+# TYPE req_count counter
+req_count_total{host="node1",path="/a b"} 5
+# HELP job_gauge Field gauge of gauge job
+# TYPE job_gauge gauge
+job_gauge 1.5
+# EOF
+`,
+		"csv": `time,name,type,labels,field,value
+2022-10-30T09:49:17Z,req,counter,host=node1;path=/a b,count,5
+2022-10-30T09:49:17Z,job,gauge,,gauge,1.5
+2022-10-30T09:49:17Z,job,gauge,,status,done
+`,
+		"logfmt": `time=2022-10-30T09:49:17Z name=req type=counter host=node1 path="/a b" count=5
+time=2022-10-30T09:49:17Z name=job type=gauge gauge=1.5 status=done
+`,
+	}
+	for format, out := range cases {
+		enc, err := NewEncoder(format)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		var buf bytes.Buffer
+		if err := enc.Encode(&buf, metrics...); err != nil {
+			t.Fatalf("%s: %+v\n", format, err)
+		}
+		assert.Equal(out, buf.String(), format)
+		assert.NotEmpty(enc.ContentType())
+	}
+
+	// csv header is written once
+	enc, _ := NewEncoder("csv")
+	var buf bytes.Buffer
+	enc.Encode(&buf, metrics[0])
+	enc.Encode(&buf, metrics[0])
+	assert.Equal(3, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+type nopEncoder struct{}
+
+func (nopEncoder) Encode(w io.Writer, metrics ...*Metric) error { return nil }
+
+func (nopEncoder) ContentType() string { return "text/plain" }
+
+func TestRegisterEncoder(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewEncoder("nop")
+	assert.EqualError(err, "Encoder format must be one of [csv,influx,json,logfmt,openmetrics,prometheus]")
+	RegisterEncoder("nop", func() Encoder { return nopEncoder{} })
+	t.Cleanup(func() {
+		encodersMu.Lock()
+		delete(encoders, "nop")
+		encodersMu.Unlock()
+	})
+	enc, err := NewEncoder("nop")
+	assert.Nil(err)
+	assert.Equal(nopEncoder{}, enc)
+}
@@ -0,0 +1,79 @@
+package exporters
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ops of emitter that may fail
+const (
+	OpEmit  = "emit"  // emit one batch
+	OpFlush = "flush" // flush queued batches when shutting down
+	OpClose = "close" // close emitter
+)
+
+// Error of batch dropped when emitter falls behind, and its queue is full.
+var ErrQueueFull = errors.New("queue is full")
+
+// An EmitError describes a failure of one emitter.
+type EmitError struct {
+	Emitter string // name of emitter
+	Op      string // one of OpEmit, OpFlush, OpClose
+	Points  int    // number of metric points in batch, 0 if not applicable
+	Err     error
+}
+
+func (e *EmitError) Error() string {
+	switch e.Op {
+	case OpEmit:
+		return fmt.Sprintf("Report %d metric points to %s error: %s", e.Points, e.Emitter, e.Err)
+	default:
+		return fmt.Sprintf("%s failed to %s: %s", e.Emitter, e.Op, e.Err)
+	}
+}
+
+func (e *EmitError) Unwrap() error {
+	return e.Err
+}
+
+// Errors aggregates failures of multiple emitters.
+type Errors []*EmitError
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (errs Errors) Unwrap() []error {
+	list := make([]error, 0, len(errs))
+	for _, err := range errs {
+		list = append(list, err)
+	}
+	return list
+}
+
+// Whether any of errors matches target, which makes errors.Is see through
+// Errors before go1.20.
+func (errs Errors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find first of errors that matches target, which makes errors.As see through
+// Errors before go1.20.
+func (errs Errors) As(target any) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rules of Filter. Each pattern is either a glob matching whole string, where
+// `*` matches any chars and `?` matches one char, e.g. `debug.*`, or a regex
+// enclosed in slashes, e.g. `/^req\.(get|post)$/`. Deny rules take precedence
+// over allow rules, and empty allow rules allow all.
+type FilterRules struct {
+	AllowNames  []string            // patterns of metric names to emit
+	DenyNames   []string            // patterns of metric names not to emit
+	AllowTypes  []MetricType        // types to emit
+	DenyTypes   []MetricType        // types not to emit
+	AllowLabels map[string][]string // patterns of label values to emit, by label name, metric without the label is not emitted
+	DenyLabels  map[string][]string // patterns of label values not to emit, by label name
+	DropFields  []string            // patterns of field names to drop
+}
+
+// A Filter decides which metrics, and which fields of them, are emitted, e.g.
+// to keep debug metrics off a paid upstream:
+//
+//    f, err := exporters.NewFilter(exporters.FilterRules{
+//        DenyNames:  []string{"debug.*"},
+//        DenyLabels: map[string][]string{"env": {"dev", "test"}},
+//        DropFields: []string{"p9999", "m15"},
+//    })
+//    rep.WithFilter(f)
+//
+// Filter is safe for concurrent use.
+type Filter struct {
+	allowNames  []*regexp.Regexp
+	denyNames   []*regexp.Regexp
+	allowTypes  map[MetricType]bool
+	denyTypes   map[MetricType]bool
+	allowLabels map[string][]*regexp.Regexp
+	denyLabels  map[string][]*regexp.Regexp
+	dropFields  []*regexp.Regexp
+}
+
+// Create filter of rules, it fails if any pattern is invalid.
+func NewFilter(rules FilterRules) (*Filter, error) {
+	var err error
+	f := &Filter{}
+	if f.allowNames, err = compilePatterns(rules.AllowNames); err != nil {
+		return nil, err
+	}
+	if f.denyNames, err = compilePatterns(rules.DenyNames); err != nil {
+		return nil, err
+	}
+	if f.dropFields, err = compilePatterns(rules.DropFields); err != nil {
+		return nil, err
+	}
+	if f.allowLabels, err = compileLabelPatterns(rules.AllowLabels); err != nil {
+		return nil, err
+	}
+	if f.denyLabels, err = compileLabelPatterns(rules.DenyLabels); err != nil {
+		return nil, err
+	}
+	f.allowTypes = typeSet(rules.AllowTypes)
+	f.denyTypes = typeSet(rules.DenyTypes)
+	return f, nil
+}
+
+// Whether metric should be emitted, regardless of its fields.
+func (f *Filter) Match(metric *Metric) bool {
+	if len(f.allowNames) > 0 && !matchAny(f.allowNames, metric.Name) {
+		return false
+	}
+	if matchAny(f.denyNames, metric.Name) {
+		return false
+	}
+	if len(f.allowTypes) > 0 && !f.allowTypes[metric.Type] {
+		return false
+	}
+	if f.denyTypes[metric.Type] {
+		return false
+	}
+	for k, patterns := range f.allowLabels {
+		v, ok := metric.Labels[k]
+		if !ok || !matchAny(patterns, v) {
+			return false
+		}
+	}
+	for k, patterns := range f.denyLabels {
+		if v, ok := metric.Labels[k]; ok && matchAny(patterns, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply filter to metric, return nil if it should not be emitted, or it has
+// no field left after dropping fields. Metric is not modified, a shallow copy
+// with remaining fields is returned if any field is dropped. It can be used as
+// Reshape.
+func (f *Filter) Apply(metric *Metric) *Metric {
+	if metric == nil || !f.Match(metric) {
+		return nil
+	}
+	if len(f.dropFields) == 0 {
+		return metric
+	}
+	var fields map[string]Value
+	for k := range metric.Fields {
+		if !matchAny(f.dropFields, k) {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]Value, len(metric.Fields))
+			for k, v := range metric.Fields {
+				fields[k] = v
+			}
+		}
+		delete(fields, k)
+	}
+	if fields == nil {
+		return metric
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	copied := *metric
+	copied.Fields = fields
+	return &copied
+}
+
+// Compile glob or regex enclosed in slashes.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter pattern %s: %w", pattern, err)
+		}
+		return re, nil
+	}
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func compileLabelPatterns(labels map[string][]string) (map[string][]*regexp.Regexp, error) {
+	res := make(map[string][]*regexp.Regexp, len(labels))
+	for k, patterns := range labels {
+		re, err := compilePatterns(patterns)
+		if err != nil {
+			return nil, err
+		}
+		res[k] = re
+	}
+	return res, nil
+}
+
+func typeSet(types []MetricType) map[MetricType]bool {
+	set := make(map[MetricType]bool, len(types))
+	for _, typ := range types {
+		set[typ] = true
+	}
+	return set
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap an emitter to emit only metrics passing filter, e.g. to keep debug
+// metrics off one upstream while others still receive them. Metrics are not
+// modified, thus it is safe to share them with other emitters.
+func NewFilterEmitter(em Emitter, f *Filter) *filterEmitter {
+	return &filterEmitter{EmitterWrapper: EmitterWrapper{em}, filter: f}
+}
+
+type filterEmitter struct {
+	EmitterWrapper
+	filter *Filter
+	guard  EmitGuard // emit under deadline even if emitter is not a ContextEmitter
+}
+
+func (this *filterEmitter) Emit(metrics ...*Metric) error {
+	return this.EmitContext(context.Background(), metrics...)
+}
+
+func (this *filterEmitter) EmitContext(ctx context.Context, metrics ...*Metric) error {
+	points := make([]*Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if metric = this.filter.Apply(metric); metric != nil {
+			points = append(points, metric)
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	return this.guard.Emit(ctx, this.Emitter, points...)
+}
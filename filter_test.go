@@ -0,0 +1,121 @@
+package exporters
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewFilter(FilterRules{
+		AllowNames:  []string{"req.*", `/^db\.(read|write)$/`},
+		DenyNames:   []string{"req.debug?"},
+		DenyTypes:   []MetricType{TypeHistogram},
+		AllowLabels: map[string][]string{"env": {"prod", "staging"}},
+		DenyLabels:  map[string][]string{"host": {"canary-*"}},
+		DropFields:  []string{"m15", "/^p99+$/"},
+	})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	newMetric := func(name string, typ MetricType, labels map[string]string) *Metric {
+		return &Metric{Name: name, Type: typ, Labels: labels,
+			Fields: map[string]Value{"count": IntValue(1)}}
+	}
+	prod := map[string]string{"env": "prod", "host": "node1"}
+	cases := []struct {
+		metric *Metric
+		match  bool
+	}{
+		{newMetric("req.latency", TypeTimer, prod), true},
+		{newMetric("db.read", TypeTimer, prod), true},
+		{newMetric("db.reads", TypeTimer, prod), false},
+		{newMetric("conn", TypeGauge, prod), false},
+		{newMetric("req.debug1", TypeCounter, prod), false},
+		{newMetric("req.debug12", TypeCounter, prod), true},
+		{newMetric("req", TypeCounter, prod), false},
+		{newMetric("req.size", TypeHistogram, prod), false},
+		{newMetric("req.latency", TypeTimer, map[string]string{"env": "dev"}), false},
+		{newMetric("req.latency", TypeTimer, nil), false},
+		{newMetric("req.latency", TypeTimer, map[string]string{"env": "staging", "host": "canary-1"}), false},
+	}
+	for _, tc := range cases {
+		assert.Equal(tc.match, f.Match(tc.metric), "%s %s %v", tc.metric.Type, tc.metric.Name, tc.metric.Labels)
+	}
+
+	metric := &Metric{Name: "req.latency", Type: TypeTimer, Labels: prod,
+		Fields: map[string]Value{"count": IntValue(1), "m15": FloatValue(1), "p99": FloatValue(2), "p999": FloatValue(3), "p50": FloatValue(4)}}
+	filtered := f.Apply(metric)
+	var fields []string
+	for k := range filtered.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	assert.Equal([]string{"count", "p50"}, fields)
+	assert.Len(metric.Fields, 5, "Should not modify metric")
+
+	only := &Metric{Name: "req.latency", Type: TypeTimer, Labels: prod, Fields: map[string]Value{"m15": FloatValue(1)}}
+	assert.Nil(f.Apply(only), "Should drop metric without fields left")
+
+	_, err = NewFilter(FilterRules{DenyNames: []string{"/(/"}})
+	assert.Error(err)
+}
+
+func TestReporterFilter(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	metrics.GetOrRegisterCounter("debug.req", reg).Inc(1)
+	metrics.GetOrRegisterGauge("conn", reg).Update(3)
+	f, _ := NewFilter(FilterRules{AllowLabels: map[string][]string{"env": {"prod"}}, DenyNames: []string{"debug.*"}})
+	var reshaped []string
+	rep := NewReporter(reg, time.Hour).WithLabel("env", "prod").WithFilter(f).
+		WithReshape(func(metric *Metric) *Metric {
+			reshaped = append(reshaped, metric.Name)
+			return metric
+		})
+	var names []string
+	for _, metric := range rep.pollMetrics() {
+		names = append(names, metric.Name)
+	}
+	sort.Strings(names)
+	sort.Strings(reshaped)
+	assert.Equal([]string{"conn", "req"}, names, "Should filter after global labels")
+	assert.Equal([]string{"conn", "req"}, reshaped, "Should filter before reshape")
+}
+
+func TestFilterEmitter(t *testing.T) {
+	assert := assert.New(t)
+	f, _ := NewFilter(FilterRules{DenyTypes: []MetricType{TypeGauge}, DropFields: []string{"m*"}})
+	fake := &fakeEmitter{name: "fake"}
+	em := NewFilterEmitter(fake, f)
+	assert.Equal("fake", em.Name())
+
+	counter := &Metric{Name: "req", Type: TypeMeter, Fields: map[string]Value{"count": IntValue(1), "m1": FloatValue(1)}}
+	gauge := &Metric{Name: "conn", Type: TypeGauge, Fields: map[string]Value{"gauge": IntValue(1)}}
+	assert.Nil(em.Emit(counter, gauge))
+	assert.Nil(em.Emit(gauge))
+	assert.Equal(1, fake.count(), "Should not emit empty batch")
+	assert.Len(fake.batches[0], 1)
+	assert.Equal(map[string]Value{"count": IntValue(1)}, fake.batches[0][0].Fields)
+	assert.Len(counter.Fields, 2, "Should not modify metric")
+}
+
+func TestFilterEmitterDeadline(t *testing.T) {
+	assert := assert.New(t)
+	f, _ := NewFilter(FilterRules{})
+	hung := &fakeEmitter{name: "hung", gate: make(chan struct{})}
+	defer close(hung.gate)
+	em := NewFilterEmitter(hung, f)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := em.EmitContext(ctx, &Metric{Name: "req", Fields: map[string]Value{"count": IntValue(1)}})
+	assert.Less(time.Since(start), time.Second)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+}
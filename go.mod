@@ -2,11 +2,15 @@ module github.com/juvenn/metric-exporters
 
 go 1.18
 
-require github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
+	github.com/stretchr/testify v1.8.1
+	google.golang.org/protobuf v1.28.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
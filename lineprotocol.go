@@ -0,0 +1,222 @@
+package exporters
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Escaping of influx line protocol, see
+// https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/#special-characters
+//
+//    measurement          comma, space
+//    tag key, tag value   comma, equals sign, space
+//    field key            comma, equals sign, space
+//    string field value   double quote, backslash
+//
+// Line feed is not supported, thus replaced with space.
+var (
+	influxNameEscaper   = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\ `)
+	influxKeyEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `)
+	influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ")
+)
+
+// Escape measurement, tag or field key. Trailing backslash is dropped, as it
+// would escape the following delimiter.
+func escapeInflux(s string, escaper *strings.Replacer) string {
+	return escaper.Replace(strings.TrimRight(s, `\`))
+}
+
+// Whether value can be written, as NaN and ±Inf are not supported.
+func isInfluxValue(v Value) bool {
+	if v.Kind() != KindFloat {
+		return true
+	}
+	f := v.Float()
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func formatInfluxValue(v Value) string {
+	switch v.Kind() {
+	case KindInt:
+		return v.String() + "i"
+	case KindUint:
+		return v.String() + "u"
+	case KindString:
+		return `"` + influxStringEscaper.Replace(v.String()) + `"`
+	case KindBool:
+		return v.String()
+	}
+	return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+}
+
+func formatInfluxTime(ts time.Time, precision string) string {
+	switch precision {
+	case "ns":
+		return strconv.FormatInt(ts.UnixNano(), 10)
+	case "u", "us":
+		return strconv.FormatInt(ts.UnixMicro(), 10)
+	case "ms":
+		return strconv.FormatInt(ts.UnixMilli(), 10)
+	default:
+		return strconv.FormatInt(ts.Unix(), 10)
+	}
+}
+
+func parseInfluxTime(s, precision string) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch precision {
+	case "ns":
+		return time.Unix(0, n), nil
+	case "u", "us":
+		return time.UnixMicro(n), nil
+	case "ms":
+		return time.UnixMilli(n), nil
+	default:
+		return time.Unix(n, 0), nil
+	}
+}
+
+// Parse one line of influx line protocol, in given timestamp precision, e.g.
+//
+//    req,host=node1 count=5i,mean=1.5,ok=true,status="done" 1667123357
+//
+// Integer, unsigned, bool and string fields are parsed as typed values, and
+// the others as float. Type of metric is left empty, as it is not encoded.
+func ParseInfluxLine(line, precision string) (*Metric, error) {
+	p := &lineParser{line: line}
+	metric := &Metric{Fields: make(map[string]Value)}
+	metric.Name = p.token(", ", ", ")
+	if metric.Name == "" {
+		return nil, p.errorf("missing measurement")
+	}
+	for p.consume(',') {
+		k := p.token("=", ",= ")
+		if k == "" || !p.consume('=') {
+			return nil, p.errorf("invalid tag key")
+		}
+		v := p.token(", ", ",= ")
+		if v == "" {
+			return nil, p.errorf("invalid tag value of %s", k)
+		}
+		if metric.Labels == nil {
+			metric.Labels = make(map[string]string)
+		}
+		metric.Labels[k] = v
+	}
+	if !p.consume(' ') {
+		return nil, p.errorf("missing fields")
+	}
+	for {
+		k := p.token("=", ",= ")
+		if k == "" || !p.consume('=') {
+			return nil, p.errorf("invalid field key")
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, p.errorf("invalid field value of %s: %s", k, err)
+		}
+		metric.Fields[k] = v
+		if !p.consume(',') {
+			break
+		}
+	}
+	if p.consume(' ') {
+		ts, err := parseInfluxTime(p.line[p.pos:], precision)
+		if err != nil {
+			return nil, p.errorf("invalid timestamp: %s", err)
+		}
+		metric.Time = ts
+		p.pos = len(p.line)
+	}
+	if p.pos < len(p.line) {
+		return nil, p.errorf("unexpected %q", p.line[p.pos:])
+	}
+	return metric, nil
+}
+
+type lineParser struct {
+	line string
+	pos  int
+}
+
+func (p *lineParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("Invalid line protocol at %d: %s", p.pos, fmt.Sprintf(format, a...))
+}
+
+func (p *lineParser) consume(c byte) bool {
+	if p.pos < len(p.line) && p.line[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Read until any unescaped stop char, where backslash followed by an escapable
+// char is unescaped, otherwise it is literal.
+func (p *lineParser) token(stops, escapable string) string {
+	var sb strings.Builder
+	for p.pos < len(p.line) {
+		c := p.line[p.pos]
+		if c == '\\' && p.pos+1 < len(p.line) && strings.IndexByte(escapable, p.line[p.pos+1]) >= 0 {
+			sb.WriteByte(p.line[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return sb.String()
+}
+
+func (p *lineParser) value() (Value, error) {
+	if p.consume('"') {
+		var sb strings.Builder
+		for p.pos < len(p.line) {
+			c := p.line[p.pos]
+			switch {
+			case c == '"':
+				p.pos++
+				return StringValue(sb.String()), nil
+			case c == '\\' && p.pos+1 < len(p.line) && (p.line[p.pos+1] == '"' || p.line[p.pos+1] == '\\'):
+				sb.WriteByte(p.line[p.pos+1])
+				p.pos += 2
+			default:
+				sb.WriteByte(c)
+				p.pos++
+			}
+		}
+		return Value{}, fmt.Errorf("unterminated string")
+	}
+	start := p.pos
+	for p.pos < len(p.line) && p.line[p.pos] != ',' && p.line[p.pos] != ' ' {
+		p.pos++
+	}
+	s := p.line[start:p.pos]
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return BoolValue(true), nil
+	case "f", "F", "false", "False", "FALSE":
+		return BoolValue(false), nil
+	case "":
+		return Value{}, fmt.Errorf("empty value")
+	}
+	switch s[len(s)-1] {
+	case 'i':
+		i, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		return IntValue(i), err
+	case 'u':
+		u, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		return UintValue(u), err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return FloatValue(f), err
+}
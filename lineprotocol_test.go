@@ -0,0 +1,93 @@
+package exporters
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInfluxLine(t *testing.T) {
+	assert := assert.New(t)
+	metric, err := ParseInfluxLine(`http\ req,path=/a\,b\=c,host=node1 count=5i,bytes=7u,ok=t,mean=1.5,status="say \"hi\"" 1667123357000`, "ms")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal("http req", metric.Name)
+	assert.Equal(map[string]string{"path": "/a,b=c", "host": "node1"}, metric.Labels)
+	assert.Equal(map[string]Value{
+		"count": IntValue(5), "bytes": UintValue(7), "ok": BoolValue(true),
+		"mean": FloatValue(1.5), "status": StringValue(`say "hi"`),
+	}, metric.Fields)
+	assert.Equal(time.UnixMilli(1667123357000), metric.Time)
+
+	metric, err = ParseInfluxLine("req value=1", "s")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.True(metric.Time.IsZero())
+
+	for _, line := range []string{
+		"", "req", "req ", "req,host value=1", "req,host= value=1", "req value=",
+		`req value="open`, "req value=1i2", "req value=1 abc", "req value=1 1 2",
+	} {
+		_, err := ParseInfluxLine(line, "s")
+		assert.Error(err, line)
+	}
+}
+
+func FuzzInfluxLine(f *testing.F) {
+	f.Add("req", "host", "node1", "count", "done", 1.5, int64(5))
+	f.Add("http req,a=b", "tag key", "v=1, 2", "field,key", `say "hi" \`, math.Inf(1), int64(-1))
+	f.Add(`a\`, `\`, `\\`, `=\ `, "\n", math.NaN(), int64(0))
+	f.Fuzz(func(t *testing.T, name, tagKey, tagVal, fieldKey, str string, float float64, integer int64) {
+		metric := &Metric{
+			Name:   name,
+			Time:   time.Unix(1667123357, 0),
+			Labels: map[string]string{tagKey: tagVal},
+			Fields: map[string]Value{
+				fieldKey:       FloatValue(float),
+				fieldKey + "i": IntValue(integer),
+				fieldKey + "s": StringValue(str),
+			},
+		}
+		line := metric.EncodeInfluxLine("s")
+		if line == "" {
+			return
+		}
+		parsed, err := ParseInfluxLine(line, "s")
+		if err != nil {
+			t.Fatalf("%s: %+v\n", line, err)
+		}
+		// expected after dropping trailing backslash and replacing line feed
+		normalize := func(s string) string {
+			return strings.ReplaceAll(strings.TrimRight(s, `\`), "\n", " ")
+		}
+		if parsed.Name != normalize(name) {
+			t.Fatalf("%s: name %q != %q", line, parsed.Name, normalize(name))
+		}
+		if k, v := normalize(tagKey), normalize(tagVal); k != "" && v != "" {
+			if parsed.Labels[k] != v {
+				t.Fatalf("%s: tag %q=%q != %q", line, k, parsed.Labels[k], v)
+			}
+		} else if len(parsed.Labels) > 0 {
+			t.Fatalf("%s: empty tag not dropped", line)
+		}
+		if !parsed.Time.Equal(metric.Time) {
+			t.Fatalf("%s: time %s != %s", line, parsed.Time, metric.Time)
+		}
+		for k, v := range metric.Fields {
+			if !isInfluxValue(v) {
+				v = Value{}
+			} else if v.Kind() == KindString {
+				v = StringValue(strings.ReplaceAll(v.String(), "\n", " "))
+			}
+			k = normalize(k)
+			if got, ok := parsed.Fields[k]; v != (Value{}) && ok && got != v {
+				t.Fatalf("%s: field %q=%s != %s", line, k, got, v)
+			}
+		}
+	})
+}
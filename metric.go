@@ -5,26 +5,26 @@ import (
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/rcrowley/go-metrics"
 )
 
 type MetricType string
 
 const (
-	TypeCounter   MetricType = "counter"
-	TypeGauge     MetricType = "gauge"
-	TypeMeter     MetricType = "meter"
-	TypeTimer     MetricType = "timer"
-	TypeHistogram MetricType = "histogram"
+	TypeCounter     MetricType = "counter"
+	TypeGauge       MetricType = "gauge"
+	TypeMeter       MetricType = "meter"
+	TypeTimer       MetricType = "timer"
+	TypeHistogram   MetricType = "histogram"
+	TypeEWMA        MetricType = "ewma"
+	TypeHealthcheck MetricType = "healthcheck"
 )
 
 type Metric struct {
-	Name   string             `json:"name"`
-	Type   MetricType         `json:"type"`
-	Time   time.Time          `json:"time"`
-	Labels map[string]string  `json:"labels,omitempty"`
-	Fields map[string]float64 `json:"fields"`
+	Name   string            `json:"name"`
+	Type   MetricType        `json:"type"`
+	Time   time.Time         `json:"time"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Fields map[string]Value  `json:"fields"`
 }
 
 // A Reshape is a function that can reshape metric, updating name, labels, or
@@ -32,156 +32,121 @@ type Metric struct {
 //
 //    req.appId.xxx.method.GET: 1 => req,appId=xxx,method=GET 1
 //
+// See NewTemplateReshape to decode it with templates, and NewRelabeler.
 type Reshape func(*Metric) *Metric
 
-func CollectMetric(name string, metric any) *Metric {
-	now := time.Now()
-	switch metric := metric.(type) {
-	case metrics.Counter:
-		ms := metric.Snapshot()
-		fields := map[string]float64{
-			"count": float64(ms.Count()),
-		}
-		return &Metric{Name: name, Type: TypeCounter, Time: now, Fields: fields}
-	case metrics.Histogram:
-		ms := metric.Snapshot()
-		ps := ms.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999, 0.9999})
-		fields := map[string]float64{
-			"count":    float64(ms.Count()),
-			"max":      float64(ms.Max()),
-			"mean":     ms.Mean(),
-			"min":      float64(ms.Min()),
-			"stddev":   ms.StdDev(),
-			"variance": ms.Variance(),
-			"p50":      ps[0],
-			"p75":      ps[1],
-			"p95":      ps[2],
-			"p99":      ps[3],
-			"p999":     ps[4],
-			"p9999":    ps[5],
-		}
-		return &Metric{Name: name, Type: TypeHistogram, Time: now, Fields: fields}
-	case metrics.Meter:
-		ms := metric.Snapshot()
-		fields := map[string]float64{
-			"count": float64(ms.Count()),
-			"m1":    ms.Rate1(),
-			"m5":    ms.Rate5(),
-			"m15":   ms.Rate15(),
-			"mean":  ms.RateMean(),
-		}
-		return &Metric{Name: name, Type: TypeMeter, Time: now, Fields: fields}
-	case metrics.Timer:
-		ms := metric.Snapshot()
-		ps := ms.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999, 0.9999})
-		fields := map[string]float64{
-			"count":    float64(ms.Count()),
-			"max":      float64(ms.Max()),
-			"mean":     ms.Mean(),
-			"min":      float64(ms.Min()),
-			"stddev":   ms.StdDev(),
-			"variance": ms.Variance(),
-			"p50":      ps[0],
-			"p75":      ps[1],
-			"p95":      ps[2],
-			"p99":      ps[3],
-			"p999":     ps[4],
-			"p9999":    ps[5],
-			"m1":       ms.Rate1(),
-			"m5":       ms.Rate5(),
-			"m15":      ms.Rate15(),
-			"meanrate": ms.RateMean(),
-		}
-		return &Metric{Name: name, Type: TypeTimer, Time: now, Fields: fields}
-	case metrics.Gauge:
-		ms := metric.Snapshot()
-		fields := map[string]float64{
-			"gauge": float64(ms.Value()),
+// Key to identify a series of metric, composed of type, name and sorted
+// labels, e.g. `counter:req,host=node1,region=us-west-2`.
+func (metric *Metric) SeriesKey() string {
+	var sb strings.Builder
+	sb.WriteString(string(metric.Type))
+	sb.WriteString(":")
+	sb.WriteString(metric.Name)
+	for _, entry := range SortByKey(metric.Labels) {
+		sb.WriteString(",")
+		sb.WriteString(entry.Key)
+		sb.WriteString("=")
+		sb.WriteString(entry.Val)
+	}
+	return sb.String()
+}
+
+// Deep copy of metric, so that it can be modified independently.
+func (metric *Metric) Clone() *Metric {
+	copied := *metric
+	if metric.Labels != nil {
+		copied.Labels = make(map[string]string, len(metric.Labels))
+		for k, v := range metric.Labels {
+			copied.Labels[k] = v
 		}
-		return &Metric{Name: name, Type: TypeGauge, Time: now, Fields: fields}
-	case metrics.GaugeFloat64:
-		ms := metric.Snapshot()
-		fields := map[string]float64{
-			"gauge": ms.Value(),
+	}
+	if metric.Fields != nil {
+		copied.Fields = make(map[string]Value, len(metric.Fields))
+		for k, v := range metric.Fields {
+			copied.Fields[k] = v
 		}
-		return &Metric{Name: name, Type: TypeGauge, Time: now, Fields: fields}
 	}
-	return nil
+	return &copied
 }
 
 // Encode metric to prometheus lines, each field will be appended to name
 // to produce a new line. Thus a metric with multiple fields will generate
-// multiple lines. Trailing line is omitted. See test for examples.
+// multiple lines, while string fields are skipped. Names are sanitized and
+// label values escaped, see PromMetricName and EscapePromLabelValue. Trailing
+// line is omitted. See test for examples.
 //
 //    name_count{region="us-west-2",host="node1"} 1027 1395066363000
 //    name_mean{region="us-west-2",host="node1"} 50 1395066363000
 //    name_max{region="us-west-2",host="node1"} 110 1395066363000
+//
+// See PromFormat to encode metrics in exposition format with conventions.
 func (metric *Metric) EncodePromLines() string {
-	var buf strings.Builder
-	for _, entry := range SortByKey(metric.Labels) {
-		k := entry.Key
-		v := entry.Val
-		if buf.Len() > 0 {
-			buf.WriteString(",")
-		}
-		buf.WriteString(fmt.Sprintf("%s=%q", k, v))
-	}
-	labels := buf.String()
-	if len(labels) != 0 {
-		labels = fmt.Sprintf("{%s}", labels)
-	}
-
+	labels := encodePromLabels(metric.Labels, "")
 	ts := metric.Time.UnixMilli()
-	if ts == 0 {
+	if metric.Time.IsZero() {
 		ts = time.Now().UnixMilli()
 	}
 	var lines strings.Builder
 	for _, entry := range SortByKey(metric.Fields) {
 		f, v := entry.Key, entry.Val
+		if !v.IsNumeric() {
+			continue
+		}
 		if lines.Len() > 0 {
 			lines.WriteString("\n")
 		}
 		// name_field{method="post",code="200"} 20 1395066363000
-		line := fmt.Sprintf("%s_%s%s %g %d", metric.Name, f, labels, v, ts)
+		line := fmt.Sprintf("%s%s %s %d", PromMetricName(metric.Name+"_"+f), labels, FormatPromValue(v), ts)
 		lines.WriteString(line)
 	}
 	return lines.String()
 }
 
-// Encode metric as influx line protocol
+// Encode metric as influx line protocol, integers are suffixed with i, e.g.
+// count=5i, unsigned integers with u, and strings are double quoted. Special
+// chars are escaped, while tags with empty key or value, and NaN or ±Inf fields
+// are dropped. It returns empty string if metric has no name or no field left.
 func (metric *Metric) EncodeInfluxLine(precision string) string {
+	name := escapeInflux(metric.Name, influxNameEscaper)
+	if name == "" {
+		return ""
+	}
 	var sb strings.Builder
-	sb.WriteString(metric.Name)
+	sb.WriteString(name)
 	// append labels
 	for _, entry := range SortByKey(metric.Labels) {
-		k, v := entry.Key, entry.Val
+		k := escapeInflux(entry.Key, influxKeyEscaper)
+		v := escapeInflux(entry.Val, influxKeyEscaper)
+		if k == "" || v == "" {
+			continue
+		}
 		sb.WriteString(",")
-		sb.WriteString(fmt.Sprintf("%s=%s", k, v))
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(v)
 	}
-	sb.WriteString(" ")
-	for i, entry := range SortByKey(metric.Fields) {
-		k, v := entry.Key, entry.Val
-		if i > 0 {
+	n := 0
+	for _, entry := range SortByKey(metric.Fields) {
+		k, v := escapeInflux(entry.Key, influxKeyEscaper), entry.Val
+		if k == "" || !isInfluxValue(v) {
+			continue
+		}
+		if n == 0 {
+			sb.WriteString(" ")
+		} else {
 			sb.WriteString(",")
 		}
-		sb.WriteString(fmt.Sprintf("%s=%g", k, v))
-		i++
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(formatInfluxValue(v))
+		n++
+	}
+	if n == 0 {
+		return ""
 	}
 	// write timestamp
-	ts := metric.Time
 	sb.WriteString(" ")
-	var tss string
-	switch precision {
-	case "ns":
-		tss = fmt.Sprintf("%d", ts.UnixNano())
-	case "u", "us":
-		tss = fmt.Sprintf("%d", ts.UnixMicro())
-	case "ms":
-		tss = fmt.Sprintf("%d", ts.UnixMilli())
-	default:
-		tss = fmt.Sprintf("%d", ts.Unix())
-	}
-	sb.WriteString(tss)
+	sb.WriteString(formatInfluxTime(metric.Time, precision))
 	return sb.String()
 }
 
@@ -1,6 +1,7 @@
 package exporters
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -15,21 +16,41 @@ func TestEncodeInfluxLine(t *testing.T) {
 		{
 			metric: &Metric{Name: "req", Type: "counter", Time: time.Unix(1667123357, 0),
 				Labels: map[string]string{"host": "localhost"},
-				Fields: map[string]float64{"count": 1}},
+				Fields: map[string]Value{"count": FloatValue(1)}},
 			out: "req,host=localhost count=1 1667123357",
 		},
 		{
 			metric: &Metric{Name: "req", Type: "counter", Time: time.Unix(1667123357, 0),
 				Labels: map[string]string{"host": "localhost", "region": "us-west-2"},
-				Fields: map[string]float64{"count": 1, "max": 10}},
+				Fields: map[string]Value{"count": FloatValue(1), "max": FloatValue(10)}},
 			out: "req,host=localhost,region=us-west-2 count=1,max=10 1667123357",
 		},
 		{
-			// name with labels
+			// name with comma is escaped
 			metric: &Metric{Name: "req,method=POST", Type: "counter", Time: time.Unix(1667123357, 0),
 				Labels: map[string]string{"host": "localhost", "region": "us-west-2"},
-				Fields: map[string]float64{"count": 1, "max": 10}},
-			out: "req,method=POST,host=localhost,region=us-west-2 count=1,max=10 1667123357",
+				Fields: map[string]Value{"count": FloatValue(1), "max": FloatValue(10)}},
+			out: `req\,method=POST,host=localhost,region=us-west-2 count=1,max=10 1667123357`,
+		},
+		{
+			// special chars, empty tags and non-finite fields
+			metric: &Metric{Name: "http req", Type: "counter", Time: time.Unix(1667123357, 0),
+				Labels: map[string]string{"path": "/a,b=c d", "empty": "", "tag key": "v\\"},
+				Fields: map[string]Value{"count": IntValue(1), "a=b": FloatValue(math.NaN()), "inf": FloatValue(math.Inf(1))}},
+			out: `http\ req,path=/a\,b\=c\ d,tag\ key=v count=1i 1667123357`,
+		},
+		{
+			// no field left
+			metric: &Metric{Name: "req", Type: "counter", Time: time.Unix(1667123357, 0),
+				Fields: map[string]Value{"nan": FloatValue(math.NaN())}},
+			out: "",
+		},
+		{
+			// typed fields
+			metric: &Metric{Name: "job", Type: "gauge", Time: time.Unix(1667123357, 0),
+				Fields: map[string]Value{"count": IntValue(5), "bytes": UintValue(7), "ok": BoolValue(true),
+					"mean": FloatValue(1.5), "status": StringValue(`say "hi" \ bye`)}},
+			out: `job bytes=7u,count=5i,mean=1.5,ok=true,status="say \"hi\" \\ bye" 1667123357`,
 		},
 	}
 	assert := assert.New(t)
@@ -47,16 +68,30 @@ func TestEncodePromLines(t *testing.T) {
 		{
 			metric: &Metric{Name: "req", Type: "counter", Time: time.Unix(1667123357, 0),
 				Labels: map[string]string{"host": "localhost"},
-				Fields: map[string]float64{"count": 1}},
+				Fields: map[string]Value{"count": FloatValue(1)}},
 			out: `req_count{host="localhost"} 1 1667123357000`,
 		},
 		{
 			metric: &Metric{Name: "req", Type: "counter", Time: time.Unix(1667123357, 0),
 				Labels: map[string]string{"host": "localhost", "region": "us-west-2"},
-				Fields: map[string]float64{"count": 1, "max": 10}},
+				Fields: map[string]Value{"count": FloatValue(1), "max": FloatValue(10)}},
 			out: `req_count{host="localhost",region="us-west-2"} 1 1667123357000
 req_max{host="localhost",region="us-west-2"} 10 1667123357000`,
 		},
+		{
+			// string fields are skipped
+			metric: &Metric{Name: "job", Type: "gauge", Time: time.Unix(1667123357, 0),
+				Fields: map[string]Value{"count": IntValue(12345678), "ok": BoolValue(true), "status": StringValue("done")}},
+			out: `job_count 12345678 1667123357000
+job_ok 1 1667123357000`,
+		},
+		{
+			// names sanitized and label values escaped
+			metric: &Metric{Name: "req.latency", Type: "timer", Time: time.Unix(1667123357, 0),
+				Labels: map[string]string{"http-path": "/a\"b\nc"},
+				Fields: map[string]Value{"p99": FloatValue(math.Inf(1))}},
+			out: `req_latency_p99{http_path="/a\"b\nc"} +Inf 1667123357000`,
+		},
 	}
 	assert := assert.New(t)
 	for _, tc := range cases {
@@ -64,3 +99,17 @@ req_max{host="localhost",region="us-west-2"} 10 1667123357000`,
 		assert.Equal(line, tc.out)
 	}
 }
+
+func TestMetricClone(t *testing.T) {
+	assert := assert.New(t)
+	metric := &Metric{Name: "req", Type: TypeCounter, Time: time.Unix(1667123357, 0),
+		Labels: map[string]string{"host": "node1"},
+		Fields: map[string]Value{"count": IntValue(1)}}
+	copied := metric.Clone()
+	assert.Equal(metric, copied)
+	copied.Labels["host"] = "node2"
+	copied.Fields["count"] = IntValue(2)
+	assert.Equal("node1", metric.Labels["host"])
+	assert.Equal(IntValue(1), metric.Fields["count"])
+	assert.Nil((&Metric{Name: "req"}).Clone().Labels)
+}
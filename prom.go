@@ -0,0 +1,294 @@
+package exporters
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sanitize name to a valid prometheus metric name, which must match
+// `[a-zA-Z_:][a-zA-Z0-9_:]*`. Invalid chars are replaced with underscore,
+// and name starting with digit is prefixed with underscore. E.g.
+//
+//    req.latency => req_latency
+//    http-200    => http_200
+func PromMetricName(name string) string {
+	return sanitizePromName(name, true)
+}
+
+// Sanitize name to a valid prometheus label name, which must match
+// `[a-zA-Z_][a-zA-Z0-9_]*`.
+func PromLabelName(name string) string {
+	return sanitizePromName(name, false)
+}
+
+func sanitizePromName(name string, colon bool) string {
+	if name == "" {
+		return "_"
+	}
+	var sb strings.Builder
+	sb.Grow(len(name) + 1)
+	for i, c := range name {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' ||
+			(colon && c == ':') || (i > 0 && c >= '0' && c <= '9')
+		switch {
+		case valid:
+			sb.WriteRune(c)
+		case i == 0 && c >= '0' && c <= '9':
+			sb.WriteByte('_')
+			sb.WriteRune(c)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+var (
+	promLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	promHelpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// Escape label value as per prometheus text exposition format, where
+// backslash, double-quote and line feed are escaped as \\, \" and \n.
+func EscapePromLabelValue(v string) string {
+	return promLabelValueEscaper.Replace(v)
+}
+
+// Escape docstring in # HELP line, where backslash and line feed are escaped
+// as \\ and \n.
+func EscapePromHelp(v string) string {
+	return promHelpEscaper.Replace(v)
+}
+
+// Format numeric value as per prometheus text exposition format, e.g. 5, 1.5,
+// NaN, +Inf, while bool is formatted as 1 or 0.
+func FormatPromValue(v Value) string {
+	switch v.Kind() {
+	case KindInt, KindUint:
+		return v.String()
+	case KindBool:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	}
+	f := v.Float()
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Conventions to encode metrics as prometheus metric families. Zero value
+// names each field as one family `name_field`, where count is typed counter,
+// and the others gauge, e.g.
+//
+//    req_count, req_latency_count, req_latency_p99, conn_gauge
+//
+// While with all conventions applied:
+//
+//    req_total, req_latency_seconds{quantile="0.99"}, req_latency_seconds_count, conn
+type PromFormat struct {
+	// Prefix each metric name with namespace, joined by underscore.
+	Namespace string
+	// Name count after metric with suffix _total, e.g. req_total instead of
+	// req_count, and gauge after metric as is, e.g. conn instead of conn_gauge.
+	TotalSuffix bool
+	// Scale timer durations, i.e. min, max, mean, stddev and percentiles, from
+	// nanoseconds to seconds, and suffix them with _seconds.
+	TimerSeconds bool
+	// Encode timers and histograms as summary families, where percentiles are
+	// samples with quantile label, along with _sum and _count. Remaining fields
+	// are encoded as gauges.
+	Summary bool
+	// Append timestamp in milliseconds to each sample.
+	Timestamps bool
+}
+
+// A family is a group of samples with same name and type, which share one
+// pair of # HELP and # TYPE lines.
+type promFamily struct {
+	name    string
+	typ     string // counter, gauge or summary
+	help    string
+	samples []promSample
+}
+
+type promSample struct {
+	suffix string // suffix to family name, e.g. _sum
+	labels string // encoded labels, including braces
+	value  Value
+	ts     int64 // timestamp in milliseconds
+}
+
+// Write metrics in text exposition format, families are in order of first
+// appearance. See https://prometheus.io/docs/instrumenting/exposition_formats/
+func (f PromFormat) WriteText(w io.Writer, metrics ...*Metric) error {
+	bw := bufio.NewWriter(w)
+	for _, fam := range f.families(metrics) {
+		bw.WriteString("# HELP " + fam.name + " " + EscapePromHelp(fam.help) + "\n")
+		bw.WriteString("# TYPE " + fam.name + " " + fam.typ + "\n")
+		for _, s := range fam.samples {
+			bw.WriteString(fam.name + s.suffix + s.labels + " " + FormatPromValue(s.value))
+			if f.Timestamps {
+				bw.WriteString(" " + strconv.FormatInt(s.ts, 10))
+			}
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// Write metrics in OpenMetrics text format, terminated by # EOF. Counter
+// samples are suffixed with _total, while family name is not, and timestamps
+// are in seconds. See https://openmetrics.io
+func (f PromFormat) WriteOpenMetrics(w io.Writer, metrics ...*Metric) error {
+	bw := bufio.NewWriter(w)
+	for _, fam := range f.families(metrics) {
+		name, suffix := fam.name, ""
+		if fam.typ == "counter" {
+			name, suffix = strings.TrimSuffix(fam.name, "_total"), "_total"
+		}
+		bw.WriteString("# HELP " + name + " " + EscapePromLabelValue(fam.help) + "\n")
+		bw.WriteString("# TYPE " + name + " " + fam.typ + "\n")
+		for _, s := range fam.samples {
+			bw.WriteString(name + suffix + s.suffix + s.labels + " " + FormatPromValue(s.value))
+			if f.Timestamps {
+				bw.WriteString(" " + strconv.FormatFloat(float64(s.ts)/1e3, 'f', -1, 64))
+			}
+			bw.WriteString("\n")
+		}
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+// Break metrics down to families, samples are grouped by family name in order
+// of first appearance. String fields are skipped.
+func (f PromFormat) families(metrics []*Metric) []*promFamily {
+	b := &promFamilies{index: make(map[string]*promFamily, len(metrics))}
+	for _, metric := range metrics {
+		name := metric.Name
+		if f.Namespace != "" {
+			name = f.Namespace + "_" + name
+		}
+		name = PromMetricName(name)
+		ts := metric.Time.UnixMilli()
+		if metric.Time.IsZero() {
+			ts = time.Now().UnixMilli()
+		}
+		labels := encodePromLabels(metric.Labels, "")
+		timer := metric.Type == TypeTimer && f.TimerSeconds
+		// fields are rendered as gauges if summary is not added
+		summary := f.Summary && (metric.Type == TypeTimer || metric.Type == TypeHistogram) &&
+			f.addSummary(b, metric, name, ts)
+		for _, entry := range SortByKey(metric.Fields) {
+			field, v := entry.Key, entry.Val
+			if !v.IsNumeric() {
+				continue
+			}
+			if summary {
+				if _, ok := ParsePercentile(field); ok || field == "count" {
+					continue
+				}
+			}
+			help := "Field " + field + " of " + string(metric.Type) + " " + metric.Name
+			famName, typ := name+"_"+PromMetricName(field), "gauge"
+			switch {
+			case metric.Type == TypeGauge:
+				if f.TotalSuffix && field == "gauge" {
+					famName = name
+				}
+			case field == "count":
+				typ = "counter"
+				if f.TotalSuffix {
+					famName = name + "_total"
+				}
+			case timer && IsTimerDuration(field):
+				famName += "_seconds"
+				v = FloatValue(v.Float() / 1e9)
+			}
+			b.add(famName, typ, help, promSample{labels: labels, value: v, ts: ts})
+		}
+	}
+	return b.families
+}
+
+// Add summary family of timer or histogram, if it has count, and return
+// whether it is added.
+func (f PromFormat) addSummary(b *promFamilies, metric *Metric, name string, ts int64) bool {
+	count, ok := metric.Fields["count"]
+	if !ok || !count.IsNumeric() {
+		return false
+	}
+	scale := 1.0
+	if metric.Type == TypeTimer && f.TimerSeconds {
+		name += "_seconds"
+		scale = 1e-9
+	}
+	help := "Summary of " + string(metric.Type) + " " + metric.Name
+	for _, entry := range SortByKey(metric.Fields) {
+		q, ok := ParsePercentile(entry.Key)
+		if !ok || !entry.Val.IsNumeric() {
+			continue
+		}
+		labels := encodePromLabels(metric.Labels, strconv.FormatFloat(q, 'g', -1, 64))
+		b.add(name, "summary", help, promSample{labels: labels, value: FloatValue(entry.Val.Float() * scale), ts: ts})
+	}
+	labels := encodePromLabels(metric.Labels, "")
+	if mean, ok := metric.Fields["mean"]; ok && mean.IsNumeric() {
+		sum := FloatValue(count.Float() * mean.Float() * scale)
+		b.add(name, "summary", help, promSample{suffix: "_sum", labels: labels, value: sum, ts: ts})
+	}
+	b.add(name, "summary", help, promSample{suffix: "_count", labels: labels, value: count, ts: ts})
+	return true
+}
+
+type promFamilies struct {
+	families []*promFamily
+	index    map[string]*promFamily
+}
+
+func (b *promFamilies) add(name, typ, help string, s promSample) {
+	fam, ok := b.index[name]
+	if !ok {
+		fam = &promFamily{name: name, typ: typ, help: help}
+		b.index[name] = fam
+		b.families = append(b.families, fam)
+	}
+	fam.samples = append(fam.samples, s)
+}
+
+// Encode labels sorted by name, with quantile label if not empty, e.g.
+// `{host="node1",quantile="0.99"}`.
+func encodePromLabels(labels map[string]string, quantile string) string {
+	if len(labels) == 0 && quantile == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, entry := range SortByKey(labels) {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(PromLabelName(entry.Key))
+		sb.WriteString(`="`)
+		sb.WriteString(EscapePromLabelValue(entry.Val))
+		sb.WriteString(`"`)
+	}
+	if quantile != "" {
+		if len(labels) > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(`quantile="` + quantile + `"`)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
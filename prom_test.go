@@ -0,0 +1,76 @@
+package exporters
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromNames(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("req_latency", PromMetricName("req.latency"))
+	assert.Equal("http_200", PromMetricName("http-200"))
+	assert.Equal("_200_ok", PromMetricName("200.ok"))
+	assert.Equal("ns:req_count", PromMetricName("ns:req_count"))
+	assert.Equal("ns_req", PromLabelName("ns:req"))
+	assert.Equal("_", PromLabelName(""))
+	assert.Equal(`a\\b\"c\nd`, EscapePromLabelValue("a\\b\"c\nd"))
+	assert.Equal(`a\\b"c\nd`, EscapePromHelp("a\\b\"c\nd"))
+}
+
+func TestPromFormat(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Unix(1667123357, 0)
+	metrics := []*Metric{
+		{Name: "req", Type: TypeCounter, Time: ts, Fields: map[string]Value{"count": IntValue(3)}},
+		{Name: "conn", Type: TypeGauge, Time: ts, Fields: map[string]Value{"gauge": IntValue(7)}},
+		{Name: "req.latency", Type: TypeTimer, Time: ts, Labels: map[string]string{"path": "/a"},
+			Fields: map[string]Value{"count": IntValue(4), "mean": FloatValue(2.5e9), "max": IntValue(4e9),
+				"p50": FloatValue(2e9), "quantile=0.99": FloatValue(4e9), "m1": FloatValue(0.5)}},
+	}
+	f := PromFormat{Namespace: "app", TotalSuffix: true, TimerSeconds: true, Summary: true, Timestamps: true}
+	var buf bytes.Buffer
+	if err := f.WriteText(&buf, metrics...); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(`# HELP app_req_total Field count of counter req
+# TYPE app_req_total counter
+app_req_total 3 1667123357000
+# HELP app_conn Field gauge of gauge conn
+# TYPE app_conn gauge
+app_conn 7 1667123357000
+# HELP app_req_latency_seconds Summary of timer req.latency
+# TYPE app_req_latency_seconds summary
+app_req_latency_seconds{path="/a",quantile="0.5"} 2 1667123357000
+app_req_latency_seconds{path="/a",quantile="0.99"} 4 1667123357000
+app_req_latency_seconds_sum{path="/a"} 10 1667123357000
+app_req_latency_seconds_count{path="/a"} 4 1667123357000
+# HELP app_req_latency_m1 Field m1 of timer req.latency
+# TYPE app_req_latency_m1 gauge
+app_req_latency_m1{path="/a"} 0.5 1667123357000
+# HELP app_req_latency_max_seconds Field max of timer req.latency
+# TYPE app_req_latency_max_seconds gauge
+app_req_latency_max_seconds{path="/a"} 4 1667123357000
+# HELP app_req_latency_mean_seconds Field mean of timer req.latency
+# TYPE app_req_latency_mean_seconds gauge
+app_req_latency_mean_seconds{path="/a"} 2.5 1667123357000
+`, buf.String())
+}
+
+func TestPromSummaryWithoutCount(t *testing.T) {
+	metric := &Metric{Name: "lat", Type: TypeTimer, Time: time.Unix(1667123357, 0),
+		Fields: map[string]Value{"p99": FloatValue(4), "mean": FloatValue(2)}}
+	var buf bytes.Buffer
+	if err := (PromFormat{Summary: true}).WriteText(&buf, metric); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(t, `# HELP lat_mean Field mean of timer lat
+# TYPE lat_mean gauge
+lat_mean 2
+# HELP lat_p99 Field p99 of timer lat
+# TYPE lat_p99 gauge
+lat_p99 4
+`, buf.String(), "Should fall back to gauges")
+}
@@ -0,0 +1,92 @@
+package exporters
+
+import (
+	"reflect"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Options of one registry, see Reporter.WithRegistry.
+type RegistryOption func(*registrySource)
+
+// A registry polled by reporter, with its labels and name prefix.
+type registrySource struct {
+	registry metrics.Registry
+	labels   map[string]string
+	prefix   string
+}
+
+// Add a label to each metric of the registry, e.g. module=billing. Repeatedly
+// apply it to add multiple labels. Global labels take precedence over labels
+// of registry.
+func RegistryLabel(k, v string) RegistryOption {
+	return func(src *registrySource) {
+		if src.labels == nil {
+			src.labels = make(map[string]string)
+		}
+		src.labels[k] = v
+	}
+}
+
+// Prefix name of each metric of the registry, e.g. "billing.".
+func RegistryPrefix(prefix string) RegistryOption {
+	return func(src *registrySource) {
+		src.prefix = prefix
+	}
+}
+
+func newRegistrySource(reg metrics.Registry, opts ...RegistryOption) *registrySource {
+	src := &registrySource{registry: reg}
+	for _, opt := range opts {
+		opt(src)
+	}
+	return src
+}
+
+// Collect metric of the registry, attaching its prefix and labels.
+func (src *registrySource) collect(c *Collector, name string, metrik any) *Metric {
+	metric := c.Collect(name, metrik)
+	if metric == nil {
+		return nil
+	}
+	metric.Name = src.prefix + metric.Name
+	if metric.Labels == nil && len(src.labels) > 0 {
+		metric.Labels = make(map[string]string)
+	}
+	for k, v := range src.labels {
+		metric.Labels[k] = v
+	}
+	return metric
+}
+
+// Unregister metric by name given by Each. Each of PrefixedRegistry gives name
+// with prefix, while Unregister prefixes name again, thus it is unregistered
+// by name without prefix, that is the shortest suffix getting same metric.
+func (src *registrySource) unregister(name string, metrik any) {
+	if _, ok := src.registry.(*metrics.PrefixedRegistry); !ok {
+		src.registry.Unregister(name)
+		return
+	}
+	for i := 0; i <= len(name); i++ {
+		if m := src.registry.Get(name[i:]); m != nil && sameMetric(m, metrik) {
+			src.registry.Unregister(name[i:])
+			return
+		}
+	}
+}
+
+// Whether a and b are the same metric, which are pointers typically, while
+// values of uncomparable types are never the same.
+func sameMetric(a, b any) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// Whether metric is a pointer, which identifies the metric instance.
+func isPointer(metrik any) bool {
+	return reflect.ValueOf(metrik).Kind() == reflect.Pointer
+}
@@ -0,0 +1,268 @@
+package exporters
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action of relabel config.
+type RelabelAction string
+
+const (
+	// Set target label to replacement, if regex matches joined source labels.
+	RelabelReplace RelabelAction = "replace"
+	// Drop metric if regex does not match joined source labels.
+	RelabelKeep RelabelAction = "keep"
+	// Drop metric if regex matches joined source labels.
+	RelabelDrop RelabelAction = "drop"
+	// Copy labels whose name matches regex to replacement as name.
+	RelabelLabelMap RelabelAction = "labelmap"
+	// Remove labels whose name matches regex.
+	RelabelLabelDrop RelabelAction = "labeldrop"
+	// Remove labels whose name does not match regex.
+	RelabelLabelKeep RelabelAction = "labelkeep"
+	// Set target label to hash of joined source labels modulo modulus.
+	RelabelHashMod RelabelAction = "hashmod"
+	// Set named groups of regex as labels, if regex matches joined source
+	// labels, which default to metric name. Target label is also set to
+	// replacement if given.
+	RelabelCapture RelabelAction = "capture"
+)
+
+// Pseudo labels of metric name and type, which can be used as source labels,
+// and metric name can also be target label.
+const (
+	LabelName = "__name__"
+	LabelType = "__type__"
+)
+
+// A relabel config in the spirit of prometheus relabel_config, applied to
+// metric labels, name and type, e.g. to decode metric name into labels, and
+// rename metric:
+//
+//    - action: capture
+//      regex: 'req\.appId\.(?P<appId>[^.]+)\.method\.(?P<method>\w+)'
+//      target_label: __name__
+//      replacement: req
+//    - action: labeldrop
+//      regex: 'tmp_.*'
+//
+// Regex is anchored at both ends, and defaults to (.*). Separator defaults to
+// `;`, and replacement defaults to $1.
+type RelabelConfig struct {
+	SourceLabels []string      `yaml:"source_labels,omitempty" json:"source_labels,omitempty"`
+	Separator    string        `yaml:"separator,omitempty" json:"separator,omitempty"`
+	Regex        string        `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Modulus      uint64        `yaml:"modulus,omitempty" json:"modulus,omitempty"`
+	TargetLabel  string        `yaml:"target_label,omitempty" json:"target_label,omitempty"`
+	Replacement  string        `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	Action       RelabelAction `yaml:"action,omitempty" json:"action,omitempty"` // default to replace
+}
+
+// Load list of relabel configs from yaml, or json which is also yaml.
+func LoadRelabelConfigs(data []byte) ([]RelabelConfig, error) {
+	var cfgs []RelabelConfig
+	if err := yaml.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("Invalid relabel configs: %w", err)
+	}
+	return cfgs, nil
+}
+
+type relabeler struct {
+	RelabelConfig
+	regex *regexp.Regexp
+}
+
+// Create a Reshape that applies relabel configs in order, where metric is
+// dropped if any config drops it. It fails if any config is invalid. It can
+// be chained with other reshape, e.g.
+//
+//    cfgs, err := exporters.LoadRelabelConfigs(data)
+//    relabel, err := exporters.NewRelabeler(cfgs...)
+//    rep.WithReshape(decodeName, relabel)
+func NewRelabeler(cfgs ...RelabelConfig) (Reshape, error) {
+	fns := make([]Reshape, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := newRelabeler(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid relabel config #%d: %w", i, err)
+		}
+		fns = append(fns, r.apply)
+	}
+	return ChainReshape(fns...), nil
+}
+
+func newRelabeler(cfg RelabelConfig) (*relabeler, error) {
+	if cfg.Action == "" {
+		cfg.Action = RelabelReplace
+	}
+	if cfg.Separator == "" {
+		cfg.Separator = ";"
+	}
+	if cfg.Regex == "" {
+		cfg.Regex = "(.*)"
+	}
+	if cfg.Replacement == "" && cfg.Action != RelabelCapture {
+		cfg.Replacement = "$1"
+	}
+	if cfg.Action == RelabelCapture && len(cfg.SourceLabels) == 0 {
+		cfg.SourceLabels = []string{LabelName}
+	}
+	regex, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Action {
+	case RelabelReplace:
+		if cfg.TargetLabel == "" {
+			return nil, fmt.Errorf("target_label is required by %s", cfg.Action)
+		}
+	case RelabelHashMod:
+		if cfg.TargetLabel == "" {
+			return nil, fmt.Errorf("target_label is required by %s", cfg.Action)
+		}
+		if cfg.Modulus == 0 {
+			return nil, fmt.Errorf("modulus is required by %s", cfg.Action)
+		}
+	case RelabelCapture:
+		named := false
+		for _, name := range regex.SubexpNames() {
+			named = named || name != ""
+		}
+		if !named {
+			return nil, fmt.Errorf("regex should have named groups for %s", cfg.Action)
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return nil, fmt.Errorf("Unknown relabel action %s", cfg.Action)
+	}
+	return &relabeler{RelabelConfig: cfg, regex: regex}, nil
+}
+
+// Apply config to metric, return nil if it is dropped.
+func (r *relabeler) apply(metric *Metric) *Metric {
+	switch r.Action {
+	case RelabelReplace:
+		value := r.sourceValue(metric)
+		if idx := r.regex.FindStringSubmatchIndex(value); idx != nil {
+			target := string(r.regex.ExpandString(nil, r.TargetLabel, value, idx))
+			setLabel(metric, target, string(r.regex.ExpandString(nil, r.Replacement, value, idx)))
+		}
+	case RelabelKeep:
+		if !r.regex.MatchString(r.sourceValue(metric)) {
+			return nil
+		}
+	case RelabelDrop:
+		if r.regex.MatchString(r.sourceValue(metric)) {
+			return nil
+		}
+	case RelabelLabelMap:
+		mapped := make(map[string]string)
+		for k, v := range metric.Labels {
+			if idx := r.regex.FindStringSubmatchIndex(k); idx != nil {
+				mapped[string(r.regex.ExpandString(nil, r.Replacement, k, idx))] = v
+			}
+		}
+		for k, v := range mapped {
+			setLabel(metric, k, v)
+		}
+	case RelabelLabelDrop:
+		for k := range metric.Labels {
+			if r.regex.MatchString(k) {
+				delete(metric.Labels, k)
+			}
+		}
+	case RelabelLabelKeep:
+		for k := range metric.Labels {
+			if !r.regex.MatchString(k) {
+				delete(metric.Labels, k)
+			}
+		}
+	case RelabelHashMod:
+		sum := md5.Sum([]byte(r.sourceValue(metric)))
+		mod := binary.BigEndian.Uint64(sum[8:]) % r.Modulus
+		setLabel(metric, r.TargetLabel, strconv.FormatUint(mod, 10))
+	case RelabelCapture:
+		value := r.sourceValue(metric)
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			break
+		}
+		for i, name := range r.regex.SubexpNames() {
+			if name != "" && match[2*i] >= 0 {
+				setLabel(metric, name, value[match[2*i]:match[2*i+1]])
+			}
+		}
+		if r.TargetLabel != "" {
+			setLabel(metric, r.TargetLabel, string(r.regex.ExpandString(nil, r.Replacement, value, match)))
+		}
+	}
+	return metric
+}
+
+// Values of source labels joined by separator.
+func (r *relabeler) sourceValue(metric *Metric) string {
+	values := make([]string, 0, len(r.SourceLabels))
+	for _, k := range r.SourceLabels {
+		switch k {
+		case LabelName:
+			values = append(values, metric.Name)
+		case LabelType:
+			values = append(values, string(metric.Type))
+		default:
+			values = append(values, metric.Labels[k])
+		}
+	}
+	return strings.Join(values, r.Separator)
+}
+
+// Set label, or metric name if k is __name__. Label is removed if v is empty,
+// while name is never set to empty.
+func setLabel(metric *Metric, k, v string) {
+	switch {
+	case k == LabelName:
+		if v != "" {
+			metric.Name = v
+		}
+	case k == "" || k == LabelType:
+	case v == "":
+		delete(metric.Labels, k)
+	default:
+		if metric.Labels == nil {
+			metric.Labels = make(map[string]string)
+		}
+		metric.Labels[k] = v
+	}
+}
+
+// Chain reshape functions into one, which applies them in order, and stops
+// once any returns nil. Nil functions are skipped, and it returns nil if none
+// is left.
+func ChainReshape(fns ...Reshape) Reshape {
+	chain := make([]Reshape, 0, len(fns))
+	for _, fn := range fns {
+		if fn != nil {
+			chain = append(chain, fn)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return func(metric *Metric) *Metric {
+		for _, fn := range chain {
+			if metric = fn(metric); metric == nil {
+				return nil
+			}
+		}
+		return metric
+	}
+}
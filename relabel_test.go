@@ -0,0 +1,143 @@
+package exporters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelabel(t *testing.T) {
+	assert := assert.New(t)
+	newMetric := func() *Metric {
+		return &Metric{Name: "req.appId.app1.method.GET", Type: TypeTimer,
+			Labels: map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0"},
+			Fields: map[string]Value{"count": IntValue(1)}}
+	}
+	cases := []struct {
+		name   string
+		cfgs   []RelabelConfig
+		labels map[string]string // nil if dropped
+		metric string
+	}{
+		{"replace", []RelabelConfig{{SourceLabels: []string{"host", "region"}, Regex: `node(\d+);(.*)`, TargetLabel: "node", Replacement: "$2-$1"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0", "node": "us-west-1"}, "req.appId.app1.method.GET"},
+		{"replace default", []RelabelConfig{{SourceLabels: []string{LabelType}, TargetLabel: "type"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0", "type": "timer"}, "req.appId.app1.method.GET"},
+		{"replace unmatched", []RelabelConfig{{SourceLabels: []string{"host"}, Regex: "node2", TargetLabel: "host", Replacement: "x"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0"}, "req.appId.app1.method.GET"},
+		{"replace name", []RelabelConfig{{SourceLabels: []string{LabelName}, Regex: `req\.(.*)`, TargetLabel: LabelName, Replacement: "http.$1"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0"}, "http.appId.app1.method.GET"},
+		{"keep", []RelabelConfig{{Action: RelabelKeep, SourceLabels: []string{"region"}, Regex: "us-.*"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0"}, "req.appId.app1.method.GET"},
+		{"keep unmatched", []RelabelConfig{{Action: RelabelKeep, SourceLabels: []string{"region"}, Regex: "us"}}, nil, ""},
+		{"drop", []RelabelConfig{{Action: RelabelDrop, SourceLabels: []string{LabelName}, Regex: `req\..*`}}, nil, ""},
+		{"labelmap", []RelabelConfig{{Action: RelabelLabelMap, Regex: "k8s_(.*)"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0", "pod": "web-0"}, "req.appId.app1.method.GET"},
+		{"labeldrop", []RelabelConfig{{Action: RelabelLabelDrop, Regex: "tmp_.*|k8s_.*"}},
+			map[string]string{"host": "node1", "region": "us-west"}, "req.appId.app1.method.GET"},
+		{"labelkeep", []RelabelConfig{{Action: RelabelLabelKeep, Regex: "host"}},
+			map[string]string{"host": "node1"}, "req.appId.app1.method.GET"},
+		{"hashmod", []RelabelConfig{{Action: RelabelHashMod, SourceLabels: []string{"host"}, Modulus: 8, TargetLabel: "shard"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0", "shard": "6"}, "req.appId.app1.method.GET"},
+		{"capture", []RelabelConfig{
+			{Action: RelabelCapture, Regex: `(\w+)\.appId\.(?P<appId>[^.]+)\.method\.(?P<method>\w+)`, TargetLabel: LabelName, Replacement: "$1"},
+			{Action: RelabelLabelKeep, Regex: "appId|method"}},
+			map[string]string{"appId": "app1", "method": "GET"}, "req"},
+		{"capture unmatched", []RelabelConfig{{Action: RelabelCapture, Regex: `db\.(?P<table>\w+)`, TargetLabel: LabelName, Replacement: "db"}},
+			map[string]string{"host": "node1", "region": "us-west", "tmp_id": "42", "k8s_pod": "web-0"}, "req.appId.app1.method.GET"},
+	}
+	for _, tc := range cases {
+		relabel, err := NewRelabeler(tc.cfgs...)
+		if err != nil {
+			t.Fatalf("%s: %+v\n", tc.name, err)
+		}
+		metric := relabel(newMetric())
+		if tc.labels == nil {
+			assert.Nil(metric, tc.name)
+			continue
+		}
+		if assert.NotNil(metric, tc.name) {
+			assert.Equal(tc.labels, metric.Labels, tc.name)
+			assert.Equal(tc.metric, metric.Name, tc.name)
+		}
+	}
+}
+
+func TestRelabelInvalid(t *testing.T) {
+	assert := assert.New(t)
+	for _, cfg := range []RelabelConfig{
+		{Action: "rename"},
+		{Regex: "node1"},
+		{Action: RelabelHashMod, TargetLabel: "shard"},
+		{Action: RelabelCapture, Regex: `req\.(\w+)`},
+		{Action: RelabelDrop, Regex: "(("},
+	} {
+		_, err := NewRelabeler(cfg)
+		assert.Error(err, "%+v", cfg)
+	}
+}
+
+func TestLoadRelabelConfigs(t *testing.T) {
+	assert := assert.New(t)
+	expected := []RelabelConfig{
+		{Action: RelabelCapture, Regex: `req\.appId\.(?P<appId>[^.]+)`, TargetLabel: LabelName, Replacement: "req"},
+		{SourceLabels: []string{"host", "region"}, Separator: "/", TargetLabel: "node"},
+	}
+	cfgs, err := LoadRelabelConfigs([]byte(`
+- action: capture
+  regex: 'req\.appId\.(?P<appId>[^.]+)'
+  target_label: __name__
+  replacement: req
+- source_labels: [host, region]
+  separator: /
+  target_label: node
+`))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(expected, cfgs)
+
+	cfgs, err = LoadRelabelConfigs([]byte(`[
+  {"action": "capture", "regex": "req\\.appId\\.(?P<appId>[^.]+)", "target_label": "__name__", "replacement": "req"},
+  {"source_labels": ["host", "region"], "separator": "/", "target_label": "node"}
+]`))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(expected, cfgs)
+
+	_, err = LoadRelabelConfigs([]byte("action: drop"))
+	assert.Error(err)
+}
+
+func TestChainReshape(t *testing.T) {
+	assert := assert.New(t)
+	var calls []string
+	reshape := func(name string, drop bool) Reshape {
+		return func(metric *Metric) *Metric {
+			calls = append(calls, name)
+			if drop {
+				return nil
+			}
+			return metric
+		}
+	}
+	rep := NewReporter(nil, 0).WithReshape(reshape("a", false)).WithReshape(reshape("b", false), reshape("c", true), reshape("d", false))
+	assert.Nil(rep.reshape(&Metric{}))
+	assert.Equal([]string{"a", "b", "c"}, calls)
+}
+
+func TestWithReshapeNil(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	rep := NewReporter(reg, time.Hour).WithReshape(nil)
+	assert.Len(rep.pollMetrics(), 1)
+	dropAll := func(metric *Metric) *Metric { return nil }
+	rep.WithReshape(dropAll, nil)
+	assert.Len(rep.pollMetrics(), 0, "Should skip nil function")
+	rep.WithReshape(nil)
+	assert.Len(rep.pollMetrics(), 1, "Should clear functions")
+}
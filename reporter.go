@@ -1,8 +1,11 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
@@ -10,25 +13,185 @@ import (
 
 // A reporter periodically cut metrics and publish to given publishers.
 type Reporter struct {
-	registry   metrics.Registry
-	interval   time.Duration // poll and report interval
-	autoRemove bool          // auto remove metric such as counter
+	registries []*registrySource // polled in order
+	interval   time.Duration     // poll and report interval
+	collector  *Collector        // collect metric into fields
+	autoRemove bool              // auto remove metric such as counter
+	delta      *deltaTracker     // convert counts to deltas if not nil
 	emitters   []Emitter
+	emitterOps []*emitterOptions // one per emitter
 	exit       chan struct{}     // signal when shutting down
+	done       chan struct{}     // closed when poll loop exits
+	ctx        context.Context   // context of poll loop, cancelled on shutdown
+	cancel     context.CancelFunc
 	labels     map[string]string // global labels attach to each metric
+	filter     *Filter           // filter metrics before reshape
 	reshape    Reshape           // metric transformer
 	logf       func(format string, a ...any)
+	onError    func(err *EmitError) // called on each failed report
+	self       *selfMetrics         // metrics of reporter itself
+	selfExport bool                 // export self metrics along with registry
+	selfPrefix string               // prefix of exported self metrics
+	collisions map[string]bool      // metrics collided across registries, logged once
+
+	dispatchers []*dispatcher // one per emitter
+	queueSize   int           // max batches queued per emitter
+	queuePolicy QueuePolicy   // policy when queue is full
+	emitTimeout time.Duration // per emit deadline
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Poll metrics from one more registry, e.g. registry of each module, along
+// with registry given to NewReporter, which can be nil to add registries all
+// by this. Options apply to metrics of this registry only, e.g.
+//
+//    rep.WithRegistry(billingReg, exporters.RegistryLabel("module", "billing")).
+//        WithRegistry(metrics.NewPrefixedRegistry("auth."))
+//
+// A metric reachable from multiple registries, e.g. a PrefixedChildRegistry
+// and its parent, is emitted once, with name and labels of last registry, thus
+// child registry added after its parent takes precedence.
+// If different metrics of registries have same name and labels, only that of
+// first registry is emitted.
+func (rep *Reporter) WithRegistry(reg metrics.Registry, opts ...RegistryOption) *Reporter {
+	rep.registries = append(rep.registries, newRegistrySource(reg, opts...))
+	return rep
 }
 
 // Add more emitter to the reporter. Repeatedly apply it to add multiple emitters.
-func (rep *Reporter) WithEmitter(emitter Emitter) *Reporter {
+// Options apply to metrics of this emitter only, after global labels, filter
+// and reshape, e.g. to tag influx with env while folding env into name of
+// graphite:
+//
+//    rep.WithEmitter(inf, exporters.EmitterLabel("env", "prod")).
+//        WithEmitter(graphite, exporters.EmitterReshape(prefixEnv))
+//
+// Each emitter receives its own copy of metrics if there are multiple
+// emitters, thus one can not mutate what another receives.
+func (rep *Reporter) WithEmitter(emitter Emitter, opts ...EmitterOption) *Reporter {
+	ops := &emitterOptions{every: 1}
+	for _, opt := range opts {
+		opt(ops)
+	}
+	if ops.filter != nil {
+		emitter = NewFilterEmitter(emitter, ops.filter)
+	}
 	rep.emitters = append(rep.emitters, emitter)
+	rep.emitterOps = append(rep.emitterOps, ops)
 	return rep
 }
 
-// Apply a function to transform metric name, labels, fields before emitting.
-func (rep *Reporter) WithReshape(fn Reshape) *Reporter {
-	rep.reshape = fn
+// Options of one emitter, see Reporter.WithEmitter.
+type EmitterOption func(*emitterOptions)
+
+type emitterOptions struct {
+	labels  map[string]string
+	filter  *Filter // wraps emitter by NewFilterEmitter
+	reshape Reshape
+	every   int           // emit every n polls
+	polls   int           // polls since last emit
+	skipped skippedDeltas // deltas of skipped polls in delta mode
+}
+
+// Add a label to each metric of the emitter. Repeatedly apply it to add
+// multiple labels.
+func EmitterLabel(k, v string) EmitterOption {
+	return func(ops *emitterOptions) {
+		if ops.labels == nil {
+			ops.labels = make(map[string]string)
+		}
+		ops.labels[k] = v
+	}
+}
+
+// Emit only metrics passing filter to the emitter, same as wrapping it by
+// NewFilterEmitter, thus applied after emitter labels and reshape.
+func EmitterFilter(f *Filter) EmitterOption {
+	return func(ops *emitterOptions) {
+		ops.filter = f
+	}
+}
+
+// Reshape metrics of the emitter, chained after previous ones, see
+// Reporter.WithReshape.
+func EmitterReshape(fns ...Reshape) EmitterOption {
+	return func(ops *emitterOptions) {
+		if ops.reshape != nil {
+			fns = append([]Reshape{ops.reshape}, fns...)
+		}
+		ops.reshape = ChainReshape(fns...)
+	}
+}
+
+// Emit to the emitter every n polls, i.e. at n times of poll interval, while
+// metrics of other polls are skipped, except that in delta mode, counts of
+// skipped polls are added to the next emitted one. Last metrics are always
+// emitted when shutting down.
+func EmitterInterval(n int) EmitterOption {
+	return func(ops *emitterOptions) {
+		if n < 1 {
+			n = 1
+		}
+		ops.every = n
+	}
+}
+
+// Whether to emit on this poll, it counts polls.
+func (ops *emitterOptions) due(last bool) bool {
+	ops.polls++
+	if last || ops.polls >= ops.every {
+		ops.polls = 0
+		return true
+	}
+	return false
+}
+
+// Copy metrics of the emitter, and apply labels and reshape.
+func (ops *emitterOptions) transform(metrics []*Metric) []*Metric {
+	points := make([]*Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		metric = metric.Clone()
+		if metric.Labels == nil && len(ops.labels) > 0 {
+			metric.Labels = make(map[string]string)
+		}
+		for k, v := range ops.labels {
+			metric.Labels[k] = v
+		}
+		if ops.reshape != nil {
+			if metric = ops.reshape(metric); metric == nil {
+				continue
+			}
+		}
+		if len(metric.Fields) > 0 {
+			points = append(points, metric)
+		}
+	}
+	return points
+}
+
+// Apply functions to transform metric name, labels, fields before emitting,
+// in order, where metric is dropped once any returns nil. Repeatedly apply it
+// to chain more functions, e.g. relabelers, see NewRelabeler. Apply it with
+// nil to clear functions applied before.
+func (rep *Reporter) WithReshape(fns ...Reshape) *Reporter {
+	if ChainReshape(fns...) == nil {
+		rep.reshape = nil
+		return rep
+	}
+	if rep.reshape != nil {
+		fns = append([]Reshape{rep.reshape}, fns...)
+	}
+	rep.reshape = ChainReshape(fns...)
+	return rep
+}
+
+// Emit only metrics passing filter, which is applied after global labels are
+// attached and before reshape. See also NewFilterEmitter to filter metrics of
+// one emitter.
+func (rep *Reporter) WithFilter(f *Filter) *Reporter {
+	rep.filter = f
 	return rep
 }
 
@@ -41,6 +204,13 @@ func (rep *Reporter) WithLabel(k, v string) *Reporter {
 	return rep
 }
 
+// Collect metrics with given collector, e.g. to customize percentiles and
+// fields of timers. Default to DefaultCollector.
+func (rep *Reporter) WithCollector(c *Collector) *Reporter {
+	rep.collector = c
+	return rep
+}
+
 // Auto remove (or not) metric from registry after polled. NOTE that all metrics
 // must be dynamically registered to registry via `GetOrRegister`, otherwise
 // they will be lost after polled.
@@ -49,25 +219,212 @@ func (rep *Reporter) WithAutoRemove(b bool) *Reporter {
 	return rep
 }
 
+// Each emitter is dispatched on its own goroutine, with a bounded queue of
+// batches. Set queue size (default to 8) and policy (default to DropOldest)
+// when an emitter falls behind.
+func (rep *Reporter) WithQueue(size int, policy QueuePolicy) *Reporter {
+	rep.queueSize = size
+	rep.queuePolicy = policy
+	return rep
+}
+
+// Deadline of each emit, default to poll interval, 0 means no deadline. See
+// ContextEmitter.
+func (rep *Reporter) WithEmitTimeout(du time.Duration) *Reporter {
+	rep.emitTimeout = du
+	return rep
+}
+
+// Report count of counter, meter, timer and histogram as delta since last
+// poll (or not), while metrics are kept registered. Counter reset is detected
+// when count decreases. It should not be combined with WithAutoRemove. Emitters
+// are notified of the mode, see DeltaEmitter.
+func (rep *Reporter) WithDelta(b bool) *Reporter {
+	if b {
+		rep.delta = newDeltaTracker()
+	} else {
+		rep.delta = nil
+	}
+	return rep
+}
+
 // Start and return reporter, the reporter should be Closed when shutting down.
 func (rep *Reporter) Start() (*Reporter, error) {
-	rep.exit = make(chan struct{})
 	if len(rep.emitters) < 1 {
 		return nil, fmt.Errorf("Please specify at least one emitter to report metrics.")
 	}
+	for _, em := range rep.emitters {
+		if em, ok := em.(DeltaEmitter); ok {
+			if err := em.SetDelta(rep.delta != nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	rep.exit = make(chan struct{})
+	rep.done = make(chan struct{})
+	rep.ctx, rep.cancel = context.WithCancel(context.Background())
+	rep.dispatchers = make([]*dispatcher, 0, len(rep.emitters))
+	for i, em := range rep.emitters {
+		if em, ok := em.(ResourceEmitter); ok {
+			labels := make(map[string]string, len(rep.labels))
+			for k, v := range rep.labels {
+				labels[k] = v
+			}
+			for k, v := range rep.emitterOps[i].labels {
+				labels[k] = v
+			}
+			em.SetResource(labels)
+		}
+		if ops := rep.emitterOps[i]; rep.delta != nil && ops.every > 1 {
+			ops.skipped = make(skippedDeltas)
+		}
+		d := newDispatcher(em, rep.queueSize, rep.queuePolicy, rep.emitTimeout, rep.logf, rep.onError, rep.self.emitter(em.Name()))
+		d.start()
+		rep.dispatchers = append(rep.dispatchers, d)
+	}
 	go rep.loopPoll()
 	return rep, nil
 }
 
-// Close reporter and emitters gracefully
+// Close reporter and emitters gracefully, same as Shutdown without deadline.
 func (rep *Reporter) Close() error {
-	close(rep.exit)
-	rep.report()
-	var err error
-	for _, em := range rep.emitters {
-		err = em.Close()
+	return rep.Shutdown(context.Background())
+}
+
+// Shutdown reporter gracefully: report last metrics, wait for queued metrics
+// to be emitted, then close emitters, all under ctx deadline. If ctx is done
+// before, in-flight emits are cancelled, and Errors reports which emitters
+// failed to flush or close. Emitters failed to flush are not closed, as they
+// may be still emitting, while idle emitters are always closed. Last metrics
+// are not reported if ctx is already done.
+//
+// It is safe to call multiple times, only the first one takes effect, and
+// subsequent calls return the same error. It is also safe to call even if
+// reporter is not started, where emitters are just closed.
+func (rep *Reporter) Shutdown(ctx context.Context) error {
+	rep.closeOnce.Do(func() {
+		rep.closeErr = rep.shutdown(ctx)
+	})
+	return rep.closeErr
+}
+
+func (rep *Reporter) shutdown(ctx context.Context) error {
+	started := rep.exit != nil
+	if started {
+		close(rep.exit)
+		select {
+		case <-rep.done:
+		case <-ctx.Done():
+			// poll loop may be blocked enqueuing to a hung emitter
+			rep.cancel()
+			<-rep.done
+		}
+		rep.cancel()
+		if ctx.Err() == nil {
+			rep.report(ctx, true)
+		}
+	}
+	// idle dispatchers are flushed already, thus emitters are closing
+	const flushing, closing = 0, 1
+	phases := make([]int32, len(rep.emitters))
+	for i := range phases {
+		if !started || rep.dispatchers[i].closeQueue() {
+			phases[i] = closing
+		}
+	}
+	// flush then close each emitter concurrently, emitters failed to flush
+	// are not closed
+	results := waitAll(ctx, len(rep.emitters), func(i int) error {
+		if atomic.LoadInt32(&phases[i]) == flushing {
+			if err := rep.dispatchers[i].wait(ctx); err != nil {
+				return err
+			}
+			atomic.StoreInt32(&phases[i], closing)
+		}
+		return rep.emitters[i].Close()
+	})
+	var errs Errors
+	for i, err := range results {
+		if err == nil {
+			continue
+		}
+		// failed, or still flushing or closing when ctx is done
+		op := OpClose
+		if atomic.LoadInt32(&phases[i]) == flushing {
+			op = OpFlush
+		}
+		errs = append(errs, &EmitError{Emitter: rep.emitters[i].Name(), Op: op, Err: err})
 	}
-	return err
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Run fn(i) for i in [0, n) concurrently, and wait until all return, or ctx
+// is done, in which case ctx error is reported for those still running.
+func waitAll(ctx context.Context, n int, fn func(i int) error) []error {
+	type result struct {
+		i   int
+		err error
+	}
+	done := make(chan result, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			done <- result{i, fn(i)}
+		}(i)
+	}
+	errs := make([]error, n)
+	pending := make([]bool, n)
+	for i := range pending {
+		pending[i] = true
+	}
+	for count := 0; count < n; count++ {
+		select {
+		case r := <-done:
+			errs[r.i] = r.err
+			pending[r.i] = false
+		case <-ctx.Done():
+			// take results ready, then give up the others
+			for drained := false; !drained; {
+				select {
+				case r := <-done:
+					errs[r.i] = r.err
+					pending[r.i] = false
+				default:
+					drained = true
+				}
+			}
+			for i := range errs {
+				if pending[i] {
+					errs[i] = ctx.Err()
+				}
+			}
+			return errs
+		}
+	}
+	return errs
+}
+
+// Handle each failed report, e.g. to alert on export failures. It is called
+// on emitter goroutines, thus should be safe for concurrent use.
+func (rep *Reporter) WithErrorHandler(fn func(err *EmitError)) *Reporter {
+	rep.onError = fn
+	return rep
+}
+
+// Export reporter self metrics along with application metrics, each name is
+// prefixed with prefix, e.g. "exporter.". See SelfRegistry.
+func (rep *Reporter) WithSelfMetrics(prefix string) *Reporter {
+	rep.selfExport = true
+	rep.selfPrefix = prefix
+	return rep
+}
+
+// Registry of reporter self metrics, such as poll duration, emit latency,
+// success and failure counts, and bytes sent per emitter.
+func (rep *Reporter) SelfRegistry() metrics.Registry {
+	return rep.self.registry
 }
 
 // Log to customized logger, default to log.Printf.
@@ -76,66 +433,158 @@ func (rep *Reporter) WithLogger(fn func(format string, a ...any)) *Reporter {
 	return rep
 }
 
-// Poll metrics from registry
+// Poll metrics from registries, where metric also reachable from a later
+// registry, or of same name and labels as one of previous registry is skipped.
 func (rep *Reporter) pollMetrics() []*Metric {
 	points := make([]*Metric, 0, 128)
-	rep.registry.Each(func(name string, metrik any) {
-		metric := CollectMetric(name, metrik)
-		if rep.autoRemove {
-			// remove metric to keep zero metrics from hanging all time
-			rep.registry.Unregister(name)
-		}
-		if metric != nil {
-			if metric.Labels == nil && len(rep.labels) > 0 {
-				metric.Labels = make(map[string]string)
+	type entry struct {
+		name   string
+		metrik any
+	}
+	entries := make([][]entry, len(rep.registries))
+	owners := make(map[any]int) // metric instance => index of last registry reaching it
+	for i, src := range rep.registries {
+		src.registry.Each(func(name string, metrik any) {
+			entries[i] = append(entries[i], entry{name, metrik})
+			if len(rep.registries) > 1 && isPointer(metrik) {
+				owners[metrik] = i
 			}
-			for k, v := range rep.labels {
-				metric.Labels[k] = v
+		})
+	}
+	seen := make(map[string]bool)
+	for i, src := range rep.registries {
+		for _, e := range entries[i] {
+			if owner, ok := owners[e.metrik]; ok && owner != i {
+				continue
+			}
+			metric := src.collect(rep.collector, e.name, e.metrik)
+			if rep.autoRemove {
+				// remove metric to keep zero metrics from hanging all time
+				src.unregister(e.name, e.metrik)
 			}
-			if rep.reshape != nil {
-				metric = rep.reshape(metric)
+			if metric == nil {
+				continue
 			}
-			// do not emit if metric has zero fields
-			if len(metric.Fields) > 0 {
+			if len(rep.registries) > 1 {
+				key := (&Metric{Name: metric.Name, Labels: metric.Labels}).SeriesKey()
+				if seen[key] {
+					if !rep.collisions[key] {
+						rep.collisions[key] = true
+						rep.logf("Metric %s of registry #%d collides with previous registry, skipped", key[1:], i)
+					}
+					continue
+				}
+				seen[key] = true
+			}
+			if metric = rep.transform(metric); metric != nil {
 				points = append(points, metric)
 			}
 		}
-	})
+	}
+	if rep.selfExport {
+		for _, metric := range rep.self.collect(rep.collector, rep.selfPrefix) {
+			if metric = rep.transform(metric); metric != nil {
+				points = append(points, metric)
+			}
+		}
+	}
+	if rep.delta != nil {
+		for _, metric := range points {
+			rep.delta.apply(metric)
+		}
+		rep.delta.commit()
+	}
 	return points
 }
 
+// Attach global labels, filter and reshape metric, return nil if it should not
+// be emitted.
+func (rep *Reporter) transform(metric *Metric) *Metric {
+	if metric == nil {
+		return nil
+	}
+	if metric.Labels == nil && len(rep.labels) > 0 {
+		metric.Labels = make(map[string]string)
+	}
+	for k, v := range rep.labels {
+		metric.Labels[k] = v
+	}
+	if rep.filter != nil {
+		if metric = rep.filter.Apply(metric); metric == nil {
+			return nil
+		}
+	}
+	if rep.reshape != nil {
+		metric = rep.reshape(metric)
+	}
+	// do not emit if metric has zero fields
+	if metric == nil || len(metric.Fields) == 0 {
+		return nil
+	}
+	return metric
+}
+
 func (rep *Reporter) loopPoll() {
 	rep.logf("Start reporting metrics (every %s) to %s ...", rep.interval, rep.emitters[0].Name())
-	ticker := time.Tick(rep.interval)
+	defer close(rep.done)
+	ticker := time.NewTicker(rep.interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-rep.exit:
 			return
-		case <-ticker:
-			rep.report()
+		case <-ticker.C:
+			rep.report(rep.ctx, false)
 		}
 	}
 }
 
-func (rep *Reporter) report() {
+// Poll and enqueue metrics to each emitter that is due, or all emitters if it
+// is the last report.
+func (rep *Reporter) report(ctx context.Context, last bool) {
+	start := time.Now()
 	metrics := rep.pollMetrics()
+	rep.self.recordPoll(start, len(metrics))
 	if len(metrics) == 0 {
 		return
 	}
-	for _, em := range rep.emitters {
-		if err := em.Emit(metrics...); err != nil {
-			rep.logf("ERROR: Report %d metric points to %s error: %s\n", len(metrics), em.Name(), err.Error())
-		} else {
-			rep.logf("Reported %d metric points to %s\n", len(metrics), em.Name())
+	for i, d := range rep.dispatchers {
+		ops := rep.emitterOps[i]
+		if !ops.due(last) {
+			if ops.skipped != nil {
+				ops.skipped.skip(metrics)
+			}
+			continue
+		}
+		batch := metrics
+		if ops.skipped != nil {
+			batch = ops.skipped.addTo(metrics)
+		}
+		if len(rep.dispatchers) > 1 || ops.labels != nil || ops.reshape != nil {
+			if batch = ops.transform(batch); len(batch) == 0 {
+				continue
+			}
+		}
+		if !d.enqueue(ctx, batch) {
+			d.fail(&EmitError{Emitter: d.emitter.Name(), Op: OpEmit, Points: len(batch), Err: ctx.Err()})
 		}
 	}
 }
 
 func NewReporter(registry metrics.Registry, pollInterval time.Duration) *Reporter {
 	rep := &Reporter{
-		registry: registry,
-		interval: pollInterval,
-		logf:     log.Printf,
+		interval:  pollInterval,
+		collector: DefaultCollector,
+		logf:      log.Printf,
+		self:      newSelfMetrics(),
+
+		queueSize:   8,
+		queuePolicy: DropOldest,
+		emitTimeout: pollInterval,
+		collisions:  make(map[string]bool),
+	}
+	if registry != nil {
+		rep.WithRegistry(registry)
 	}
 	return rep
 }
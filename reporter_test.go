@@ -0,0 +1,389 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownDeadline(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	hung := &fakeEmitter{name: "hung", gate: make(chan struct{})}
+	defer close(hung.gate)
+	ok := &fakeEmitter{name: "ok"}
+	rep, err := NewReporter(reg, time.Hour).
+		WithEmitter(hung).
+		WithEmitter(ok).
+		WithLogger(func(string, ...any) {}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = rep.Shutdown(ctx)
+	assert.Less(time.Since(start), time.Second)
+	assert.EqualError(err, "hung failed to flush: context deadline exceeded")
+	assert.Equal(1, ok.count(), "Should flush last metrics")
+
+	// idempotent
+	assert.Equal(err, rep.Close())
+}
+
+func TestShutdownBlockedQueue(t *testing.T) {
+	for _, timeout := range []time.Duration{10 * time.Millisecond, 0} {
+		reg := metrics.NewRegistry()
+		metrics.GetOrRegisterCounter("req", reg).Inc(1)
+		hung := &fakeEmitter{name: "hung", gate: make(chan struct{})}
+		rep, err := NewReporter(reg, 10*time.Millisecond).
+			WithEmitter(hung).
+			WithQueue(1, Block).
+			WithEmitTimeout(timeout).
+			WithLogger(func(string, ...any) {}).
+			Start()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		// wait until poll loop is blocked on full queue
+		time.Sleep(100 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		err = rep.Shutdown(ctx)
+		assert.Less(t, time.Since(start), time.Second, "timeout %s", timeout)
+		assert.EqualError(t, err, "hung failed to flush: context deadline exceeded")
+		cancel()
+		close(hung.gate)
+	}
+}
+
+func TestShutdownExpired(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	hung := &fakeEmitter{name: "hung", gate: make(chan struct{})}
+	defer close(hung.gate)
+	ok := &fakeEmitter{name: "ok"}
+	rep, err := NewReporter(reg, time.Hour).
+		WithEmitter(hung).
+		WithEmitter(ok).
+		WithLogger(func(string, ...any) {}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	rep.report(context.Background(), false)
+	// wait until ok emitter is idle
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = rep.Shutdown(ctx)
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Should return Errors, but got %#v", err)
+	}
+	assert.Equal(&EmitError{Emitter: "hung", Op: OpFlush, Err: context.Canceled}, errs[0])
+	for _, e := range errs[1:] {
+		assert.NotEqual(OpFlush, e.Op, "Should flush idle emitter")
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.True(ok.isClosed(), "Should close idle emitter")
+	assert.False(hung.isClosed())
+}
+
+func TestCloseNotStarted(t *testing.T) {
+	rep := NewReporter(metrics.NewRegistry(), time.Second).WithEmitter(&fakeEmitter{name: "em"})
+	assert.Nil(t, rep.Close())
+	assert.Nil(t, rep.Close())
+}
+
+// An emitter always fails to emit and close.
+type failingEmitter struct {
+	name string
+}
+
+func (em *failingEmitter) Name() string { return em.name }
+
+func (em *failingEmitter) Close() error { return errors.New("close error") }
+
+func (em *failingEmitter) Emit(metrics ...*Metric) error { return errors.New("emit error") }
+
+func TestAggregateErrors(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	metrics.GetOrRegisterCounter("resp", reg).Inc(1)
+	var mu sync.Mutex
+	var failures []*EmitError
+	rep, err := NewReporter(reg, time.Hour).
+		WithEmitter(&failingEmitter{name: "a"}).
+		WithEmitter(&fakeEmitter{name: "ok"}).
+		WithEmitter(&failingEmitter{name: "b"}).
+		WithLogger(func(string, ...any) {}).
+		WithErrorHandler(func(err *EmitError) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, err)
+		}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	err = rep.Close()
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Should return Errors, but got %#v", err)
+	}
+	assert.Len(errs, 2)
+	assert.Equal(&EmitError{Emitter: "a", Op: OpClose, Err: errors.New("close error")}, errs[0])
+	assert.Equal("b failed to close: close error", errs[1].Error())
+
+	assert.Len(failures, 2)
+	for _, f := range failures {
+		assert.Equal(OpEmit, f.Op)
+		assert.Equal(2, f.Points)
+		assert.EqualError(f, "Report 2 metric points to "+f.Emitter+" error: emit error")
+	}
+}
+
+func TestErrorsIsAs(t *testing.T) {
+	assert := assert.New(t)
+	errs := Errors{
+		{Emitter: "a", Op: OpClose, Err: errors.New("close error")},
+		{Emitter: "b", Op: OpFlush, Err: context.DeadlineExceeded},
+	}
+	// call methods directly, as errors.Is follows Unwrap() []error since go1.20
+	assert.True(errs.Is(context.DeadlineExceeded))
+	assert.False(errs.Is(context.Canceled))
+	var emitErr *EmitError
+	assert.True(errs.As(&emitErr))
+	assert.Equal("a", emitErr.Emitter)
+	assert.True(errors.Is(error(errs), context.DeadlineExceeded))
+}
+
+func TestSelfMetrics(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	em := &fakeEmitter{name: "fake"}
+	rep, err := NewReporter(reg, 50*time.Millisecond).
+		WithEmitter(em).
+		WithEmitter(&failingEmitter{name: "failing"}).
+		WithSelfMetrics("exporter.").
+		WithLogger(func(string, ...any) {}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	rep.Close()
+
+	self := rep.SelfRegistry()
+	polls := self.Get("reporter.poll.duration").(metrics.Timer).Count()
+	assert.GreaterOrEqual(polls, int64(3))
+	assert.Equal(polls, self.Get("emitter.fake.emit.success").(metrics.Counter).Count())
+	assert.Equal(int64(0), self.Get("emitter.fake.emit.failure").(metrics.Counter).Count())
+	assert.Equal(polls, self.Get("emitter.failing.emit.failure").(metrics.Counter).Count())
+
+	// last batch includes self metrics of previous polls
+	batch := em.batches[len(em.batches)-1]
+	names := make(map[string]bool)
+	for _, m := range batch {
+		names[m.Name] = true
+		if m.Name == "exporter.emitter.emit.success" && m.Labels["emitter"] == "fake" {
+			assert.Equal(IntValue(polls-1), m.Fields["count"])
+		}
+	}
+	assert.True(names["req"])
+	assert.True(names["exporter.reporter.poll.duration"])
+	assert.True(names["exporter.emitter.emit.failure"])
+}
+
+func TestDeltaMode(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	counter := metrics.GetOrRegisterCounter("req", reg)
+	meter := metrics.GetOrRegisterMeter("rate", reg)
+	gauge := metrics.GetOrRegisterGauge("conn", reg)
+	rep := NewReporter(reg, time.Hour).WithDelta(true)
+	poll := func() map[string]int64 {
+		counts := make(map[string]int64)
+		for _, metric := range rep.pollMetrics() {
+			if v, ok := metric.Fields["count"]; ok {
+				counts[metric.Name] = v.Int()
+			} else {
+				counts[metric.Name] = metric.Fields["gauge"].Int()
+			}
+		}
+		return counts
+	}
+
+	counter.Inc(5)
+	meter.Mark(3)
+	gauge.Update(7)
+	assert.Equal(map[string]int64{"req": 5, "rate": 3, "conn": 7}, poll(), "First poll starts from 0")
+
+	counter.Inc(2)
+	assert.Equal(map[string]int64{"req": 2, "rate": 0, "conn": 7}, poll())
+
+	counter.Clear()
+	counter.Inc(1)
+	assert.Equal(map[string]int64{"req": 1, "rate": 0, "conn": 7}, poll(), "Counter reset")
+	assert.Equal(int64(1), counter.Count(), "Should keep metric registered")
+}
+
+func TestEmitterOptions(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	metrics.GetOrRegisterCounter("debug", reg).Inc(1)
+	inf := &fakeEmitter{name: "influx"}
+	graphite := &fakeEmitter{name: "graphite"}
+	slow := &fakeEmitter{name: "slow"}
+	// filter is applied after reshape
+	noDebug, _ := NewFilter(FilterRules{DenyNames: []string{"prod.debug"}})
+	rep, err := NewReporter(reg, time.Hour).
+		WithLabel("host", "node1").
+		WithEmitter(inf, EmitterLabel("env", "prod")).
+		WithEmitter(graphite, EmitterLabel("env", "prod"), EmitterFilter(noDebug),
+			EmitterReshape(func(metric *Metric) *Metric {
+				metric.Name = metric.Labels["env"] + "." + metric.Name
+				delete(metric.Labels, "env")
+				metric.Fields["count"] = IntValue(0)
+				return metric
+			})).
+		WithEmitter(slow, EmitterInterval(2)).
+		WithLogger(func(string, ...any) {}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for i := 0; i < 3; i++ {
+		rep.report(context.Background(), false)
+	}
+	rep.Close()
+
+	assert.Equal(4, inf.count())
+	assert.Equal(4, graphite.count())
+	assert.Equal(2, slow.count(), "Should emit every 2 polls, and when closing")
+	for _, m := range inf.batches[0] {
+		assert.Equal(map[string]string{"host": "node1", "env": "prod"}, m.Labels)
+		assert.Equal(IntValue(1), m.Fields["count"], "Should not be mutated by other emitters")
+	}
+	assert.Len(graphite.batches[0], 1)
+	assert.Equal("prod.req", graphite.batches[0][0].Name)
+	assert.Equal(map[string]string{"host": "node1"}, graphite.batches[0][0].Labels)
+	for _, m := range slow.batches[0] {
+		assert.Equal(map[string]string{"host": "node1"}, m.Labels)
+	}
+}
+
+func TestEmitterIntervalDelta(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	counter := metrics.GetOrRegisterCounter("req", reg)
+	every := &fakeEmitter{name: "every"}
+	slow := &fakeEmitter{name: "slow"}
+	rep, err := NewReporter(reg, time.Hour).
+		WithDelta(true).
+		WithEmitter(every).
+		WithEmitter(slow, EmitterInterval(3)).
+		WithLogger(func(string, ...any) {}).
+		Start()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for i := 1; i <= 4; i++ {
+		counter.Inc(int64(i))
+		rep.report(context.Background(), false)
+	}
+	counter.Inc(5)
+	rep.Close()
+
+	counts := func(em *fakeEmitter) []int64 {
+		var counts []int64
+		for _, batch := range em.batches {
+			counts = append(counts, batch[0].Fields["count"].Int())
+		}
+		return counts
+	}
+	assert.Equal([]int64{1, 2, 3, 4, 5}, counts(every))
+	assert.Equal([]int64{6, 9}, counts(slow), "Should add up counts of skipped polls")
+}
+
+func TestMultipleRegistries(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	billing := metrics.NewRegistry()
+	other := metrics.NewRegistry()
+	auth := metrics.NewPrefixedChildRegistry(reg, "auth.")
+	metrics.GetOrRegisterCounter("req", reg).Inc(1)
+	metrics.GetOrRegisterCounter("login", auth).Inc(2)
+	metrics.GetOrRegisterCounter("req", billing).Inc(3)
+	metrics.GetOrRegisterCounter("invoice", billing).Inc(4)
+	metrics.GetOrRegisterCounter("req", other).Inc(5)
+	metrics.GetOrRegisterCounter("queue", other).Inc(6)
+	var logs []string
+	rep := NewReporter(reg, time.Hour).
+		WithRegistry(auth).
+		WithRegistry(billing, RegistryLabel("module", "billing"), RegistryPrefix("billing.")).
+		WithRegistry(other).
+		WithLabel("host", "node1").
+		WithLogger(func(format string, a ...any) {
+			logs = append(logs, format)
+		})
+	for i := 0; i < 2; i++ {
+		counts := make(map[string]int64)
+		for _, metric := range rep.pollMetrics() {
+			counts[metric.Name+" "+metric.Labels["module"]] = metric.Fields["count"].Int()
+			assert.Equal("node1", metric.Labels["host"])
+		}
+		assert.Equal(map[string]int64{
+			"req ":                    1,
+			"auth.login ":             2,
+			"billing.req billing":     3,
+			"billing.invoice billing": 4,
+			"queue ":                  6,
+		}, counts)
+	}
+	assert.Len(logs, 1, "Should log each collision once")
+}
+
+func TestLabelledChildRegistry(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	auth := metrics.NewPrefixedChildRegistry(reg, "auth.")
+	metrics.GetOrRegisterCounter("login", auth).Inc(2)
+	var logs []string
+	rep := NewReporter(reg, time.Hour).
+		WithRegistry(auth, RegistryLabel("module", "auth")).
+		WithLogger(func(format string, a ...any) {
+			logs = append(logs, format)
+		})
+	points := rep.pollMetrics()
+	assert.Len(points, 1, "Should emit metric of parent and child once")
+	assert.Equal("auth.login", points[0].Name)
+	assert.Equal(map[string]string{"module": "auth"}, points[0].Labels, "Should take labels of child")
+	assert.Equal(IntValue(2), points[0].Fields["count"])
+	assert.Empty(logs, "Should not log same metric as collision")
+}
+
+func TestAutoRemovePrefixedRegistry(t *testing.T) {
+	assert := assert.New(t)
+	reg := metrics.NewRegistry()
+	child := metrics.NewPrefixedChildRegistry(metrics.NewPrefixedChildRegistry(reg, "app."), "db.")
+	metrics.GetOrRegisterCounter("query", child).Inc(1)
+	rep := NewReporter(nil, time.Hour).WithRegistry(child).WithAutoRemove(true)
+	points := rep.pollMetrics()
+	assert.Len(points, 1)
+	assert.Equal("app.db.query", points[0].Name)
+	assert.Nil(reg.Get("app.db.query"), "Should unregister by name without prefix")
+	assert.Len(rep.pollMetrics(), 0)
+}
@@ -0,0 +1,124 @@
+package exporters
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Metrics of reporter itself, recorded in a dedicated registry, as:
+//
+//    reporter.poll.duration        timer of each poll
+//    reporter.poll.points          histogram of points per poll
+//    emitter.<name>.emit.latency   timer of each emit
+//    emitter.<name>.emit.success   counter of succeeded reports
+//    emitter.<name>.emit.failure   counter of failed reports, including dropped
+//    emitter.<name>.bytes          counter of bytes sent, see ByteCounter
+//
+// When exported, name of emitter is decoded into label `emitter`, e.g.
+// `emitter.<name>.bytes` is exported as `<prefix>emitter.bytes,emitter=<name>`.
+type selfMetrics struct {
+	registry     metrics.Registry
+	pollDuration metrics.Timer
+	pollPoints   metrics.Histogram
+
+	mu       sync.Mutex
+	emitters map[string]*emitterMetrics
+	exported map[string]exportedName // registry name => exported name and labels
+}
+
+type emitterMetrics struct {
+	latency metrics.Timer
+	success metrics.Counter
+	failure metrics.Counter
+	bytes   metrics.Counter
+}
+
+type exportedName struct {
+	name   string
+	labels map[string]string
+}
+
+func newSelfMetrics() *selfMetrics {
+	reg := metrics.NewRegistry()
+	self := &selfMetrics{
+		registry:     reg,
+		pollDuration: metrics.NewTimer(),
+		pollPoints:   metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015)),
+		emitters:     make(map[string]*emitterMetrics),
+		exported:     make(map[string]exportedName),
+	}
+	self.register("reporter.poll.duration", "reporter.poll.duration", nil, self.pollDuration)
+	self.register("reporter.poll.points", "reporter.poll.points", nil, self.pollPoints)
+	return self
+}
+
+func (self *selfMetrics) register(name, exported string, labels map[string]string, metric any) {
+	self.registry.Register(name, metric)
+	self.exported[name] = exportedName{exported, labels}
+}
+
+// Get or register metrics of emitter by name.
+func (self *selfMetrics) emitter(name string) *emitterMetrics {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if em, ok := self.emitters[name]; ok {
+		return em
+	}
+	em := &emitterMetrics{
+		latency: metrics.NewTimer(),
+		success: metrics.NewCounter(),
+		failure: metrics.NewCounter(),
+		bytes:   metrics.NewCounter(),
+	}
+	labels := map[string]string{"emitter": name}
+	prefix := "emitter." + name + "."
+	self.register(prefix+"emit.latency", "emitter.emit.latency", labels, em.latency)
+	self.register(prefix+"emit.success", "emitter.emit.success", labels, em.success)
+	self.register(prefix+"emit.failure", "emitter.emit.failure", labels, em.failure)
+	self.register(prefix+"bytes", "emitter.bytes", labels, em.bytes)
+	self.emitters[name] = em
+	return em
+}
+
+func (self *selfMetrics) recordPoll(start time.Time, points int) {
+	self.pollDuration.UpdateSince(start)
+	self.pollPoints.Update(int64(points))
+}
+
+// Record latency and bytes sent of one emit, and count if succeeded. Failures
+// are counted on failed report, including dropped.
+func (em *emitterMetrics) recordEmit(start time.Time, bytes int64, err error) {
+	em.latency.UpdateSince(start)
+	em.bytes.Inc(bytes)
+	if err == nil {
+		em.success.Inc(1)
+	}
+}
+
+// Collect self metrics to be exported, names are prefixed and emitter names
+// are decoded into labels.
+func (self *selfMetrics) collect(collector *Collector, prefix string) []*Metric {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	points := make([]*Metric, 0, len(self.exported))
+	self.registry.Each(func(name string, metrik any) {
+		metric := collector.Collect(name, metrik)
+		if metric == nil {
+			return
+		}
+		if exported, ok := self.exported[name]; ok {
+			metric.Name = exported.name
+			if len(exported.labels) > 0 {
+				metric.Labels = make(map[string]string, len(exported.labels))
+				for k, v := range exported.labels {
+					metric.Labels[k] = v
+				}
+			}
+		}
+		metric.Name = prefix + metric.Name
+		points = append(points, metric)
+	})
+	return points
+}
@@ -0,0 +1,264 @@
+package exporters
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Rules to decode dotted metric names into name, labels and fields, with
+// graphite templates in the spirit of telegraf. Each template is of
+//
+//    [filter] template [label=value,...]
+//
+// where template parts separated by dot are one of:
+//
+//    measurement   part of metric name, joined by separator
+//    measurement*  remaining parts of metric name
+//    field         part of field name, parts are joined by `_`, which
+//                  replaces single field of metric, or prefixes each of
+//                  multiple fields with `_`
+//    field*        remaining parts of field name
+//    label         a label of the name, with part as value
+//    *             a label named by previous template part, e.g. `appId.*`
+//    _ or empty    part to skip
+//
+// `name` is an alias of `measurement`. E.g. template
+// `measurement.appId.*.method.*` decodes `req.appId.xxx.method.GET` into
+// `req,appId=xxx,method=GET`, and `.host.measurement*` decodes
+// `servers.node1.cpu.idle` into `cpu.idle,host=node1`.
+//
+// Filter is a dotted pattern matching leading parts of metric name, where each
+// part is a glob, e.g. `req.*`. Template of the most specific filter wins, that
+// is of most parts, then of most literal parts, then first of them. Template
+// without filter is the default, and metric is unchanged if no template
+// matches.
+type TemplateRules struct {
+	Templates []string          `yaml:"templates" json:"templates"`
+	Separator string            `yaml:"separator,omitempty" json:"separator,omitempty"` // join measurement parts, default to "."
+	Labels    map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`       // default labels of all templates
+}
+
+type templatePart struct {
+	kind   string // measurement, field, label, skip
+	label  string // name of label
+	greedy bool   // take remaining parts
+}
+
+type template struct {
+	filter   []string // empty for default template
+	literals int      // number of filter parts without wildcard
+	parts    []templatePart
+	labels   map[string]string
+}
+
+// Create a Reshape that decodes metric name with graphite templates, it fails
+// if any template is invalid. See TemplateRules, e.g.
+//
+//    decode, err := exporters.NewTemplateReshape(exporters.TemplateRules{
+//        Templates: []string{
+//            "req.* measurement.appId.*.method.*",
+//            "servers.* .host.measurement* dc=us-west",
+//            "measurement*",
+//        },
+//    })
+//    rep.WithReshape(decode)
+//
+// Labels decoded from name override those of metric, which in turn override
+// default labels.
+func NewTemplateReshape(rules TemplateRules) (Reshape, error) {
+	sep := rules.Separator
+	if sep == "" {
+		sep = "."
+	}
+	templates := make([]*template, 0, len(rules.Templates))
+	filters := make(map[string]bool)
+	for _, spec := range rules.Templates {
+		tmpl, err := parseTemplate(spec)
+		if err != nil {
+			return nil, err
+		}
+		filter := strings.Join(tmpl.filter, ".")
+		if filters[filter] {
+			return nil, fmt.Errorf("Duplicate template filter in %q", spec)
+		}
+		filters[filter] = true
+		for k, v := range rules.Labels {
+			if _, ok := tmpl.labels[k]; !ok {
+				tmpl.labels[k] = v
+			}
+		}
+		templates = append(templates, tmpl)
+	}
+	// most specific first, stable to keep first of same specificity
+	sort.SliceStable(templates, func(i, j int) bool {
+		a, b := templates[i], templates[j]
+		if len(a.filter) != len(b.filter) {
+			return len(a.filter) > len(b.filter)
+		}
+		return a.literals > b.literals
+	})
+	return func(metric *Metric) *Metric {
+		if metric == nil {
+			return nil
+		}
+		parts := strings.Split(metric.Name, ".")
+		for _, tmpl := range templates {
+			if tmpl.match(parts) {
+				tmpl.apply(metric, parts, sep)
+				break
+			}
+		}
+		return metric
+	}, nil
+}
+
+func parseTemplate(spec string) (*template, error) {
+	tokens := strings.Fields(spec)
+	tmpl := &template{labels: make(map[string]string)}
+	switch {
+	case len(tokens) == 1:
+	case len(tokens) == 2 && strings.Contains(tokens[1], "="):
+		if err := parseTemplateLabels(tokens[1], tmpl.labels); err != nil {
+			return nil, fmt.Errorf("Invalid template %q: %w", spec, err)
+		}
+		tokens = tokens[:1]
+	case len(tokens) == 2 || len(tokens) == 3:
+		tmpl.filter = strings.Split(tokens[0], ".")
+		for _, p := range tmpl.filter {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("Invalid template filter %q: %w", spec, err)
+			}
+			if !strings.ContainsAny(p, `*?[\`) {
+				tmpl.literals++
+			}
+		}
+		if len(tokens) == 3 {
+			if err := parseTemplateLabels(tokens[2], tmpl.labels); err != nil {
+				return nil, fmt.Errorf("Invalid template %q: %w", spec, err)
+			}
+		}
+		tokens = tokens[1:2]
+	default:
+		return nil, fmt.Errorf("Invalid template %q", spec)
+	}
+
+	fields := strings.Split(tokens[0], ".")
+	for i, f := range fields {
+		part := templatePart{}
+		switch f {
+		case "measurement", "name":
+			part.kind = "measurement"
+		case "measurement*", "name*":
+			part.kind, part.greedy = "measurement", true
+		case "field":
+			part.kind = "field"
+		case "field*":
+			part.kind, part.greedy = "field", true
+		case "", "_":
+			part.kind = "skip"
+		case "*":
+			if i == 0 || tmpl.parts[i-1].kind != "label" {
+				return nil, fmt.Errorf("Invalid template %q: * should follow a label name", spec)
+			}
+			prev := &tmpl.parts[i-1]
+			part.kind, part.label = "label", prev.label
+			prev.kind, prev.label = "skip", ""
+		default:
+			if strings.ContainsAny(f, "*=") {
+				return nil, fmt.Errorf("Invalid template %q: bad part %q", spec, f)
+			}
+			part.kind, part.label = "label", f
+		}
+		if part.greedy && i != len(fields)-1 {
+			return nil, fmt.Errorf("Invalid template %q: %s should be last part", spec, f)
+		}
+		tmpl.parts = append(tmpl.parts, part)
+	}
+	return tmpl, nil
+}
+
+// Parse labels of `k1=v1,k2=v2`.
+func parseTemplateLabels(s string, labels map[string]string) error {
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("bad label %q", pair)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// Whether filter matches leading parts of name.
+func (tmpl *template) match(parts []string) bool {
+	if len(parts) < len(tmpl.filter) {
+		return false
+	}
+	for i, p := range tmpl.filter {
+		if ok, _ := path.Match(p, parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (tmpl *template) apply(metric *Metric, parts []string, sep string) {
+	var measurement, field []string
+	labels := make(map[string]string)
+	for i, part := range tmpl.parts {
+		if i >= len(parts) {
+			break
+		}
+		values := parts[i : i+1]
+		if part.greedy {
+			values = parts[i:]
+		}
+		switch part.kind {
+		case "measurement":
+			measurement = append(measurement, values...)
+		case "field":
+			field = append(field, values...)
+		case "label":
+			if parts[i] != "" {
+				labels[part.label] = parts[i]
+			}
+		}
+	}
+	if name := strings.Join(nonEmpty(measurement), sep); name != "" {
+		metric.Name = name
+	}
+	if metric.Labels == nil && len(labels)+len(tmpl.labels) > 0 {
+		metric.Labels = make(map[string]string)
+	}
+	for k, v := range tmpl.labels {
+		if _, ok := metric.Labels[k]; !ok {
+			metric.Labels[k] = v
+		}
+	}
+	for k, v := range labels {
+		metric.Labels[k] = v
+	}
+	if prefix := strings.Join(nonEmpty(field), "_"); prefix != "" {
+		fields := make(map[string]Value, len(metric.Fields))
+		for k, v := range metric.Fields {
+			if len(metric.Fields) == 1 {
+				fields[prefix] = v
+			} else {
+				fields[prefix+"_"+k] = v
+			}
+		}
+		metric.Fields = fields
+	}
+}
+
+func nonEmpty(parts []string) []string {
+	res := parts[:0:0]
+	for _, p := range parts {
+		if p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
@@ -0,0 +1,102 @@
+package exporters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateReshape(t *testing.T) {
+	assert := assert.New(t)
+	decode, err := NewTemplateReshape(TemplateRules{
+		Templates: []string{
+			"measurement*",
+			"req.* measurement.appId.*.method.*",
+			"req.appId.*.method.POST measurement.appId.*.method.* write=true",
+			"servers.* .host.measurement* dc=us-west",
+			"stats.*.*.* _.measurement.field*",
+			"db.* measurement.table",
+			"*.cache measurement.measurement.field",
+		},
+		Separator: "_",
+		Labels:    map[string]string{"env": "prod", "dc": "us-east"},
+	})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	count := map[string]Value{"count": IntValue(1)}
+	timer := map[string]Value{"count": IntValue(1), "p99": FloatValue(2)}
+	cases := []struct {
+		in     string
+		labels map[string]string
+		fields map[string]Value
+		name   string
+		out    map[string]string
+		outf   map[string]Value
+	}{
+		{"req.appId.xxx.method.GET", nil, count,
+			"req", map[string]string{"appId": "xxx", "method": "GET", "env": "prod", "dc": "us-east"}, count},
+		{"req.appId.xxx.method.POST", nil, count,
+			"req", map[string]string{"appId": "xxx", "method": "POST", "env": "prod", "dc": "us-east", "write": "true"}, count},
+		{"req.appId.xxx", nil, count,
+			"req", map[string]string{"appId": "xxx", "env": "prod", "dc": "us-east"}, count},
+		{"req.appId..method.GET", nil, count,
+			"req", map[string]string{"method": "GET", "env": "prod", "dc": "us-east"}, count},
+		{"servers.node1.cpu.idle", map[string]string{"env": "dev"}, count,
+			"cpu_idle", map[string]string{"host": "node1", "env": "dev", "dc": "us-west"}, count},
+		{"stats.app.latency.mean.ms", nil, timer,
+			"app", map[string]string{"env": "prod", "dc": "us-east"},
+			map[string]Value{"latency_mean_ms_count": IntValue(1), "latency_mean_ms_p99": FloatValue(2)}},
+		{"stats.app.errors", nil, count,
+			"stats_app_errors", map[string]string{"env": "prod", "dc": "us-east"}, count},
+		{"db.users", nil, count,
+			"db", map[string]string{"table": "users", "env": "prod", "dc": "us-east"}, count},
+		{"redis.cache.hits", nil, count,
+			"redis_cache", map[string]string{"env": "prod", "dc": "us-east"}, map[string]Value{"hits": IntValue(1)}},
+		{"redis.cache", nil, count,
+			"redis_cache", map[string]string{"env": "prod", "dc": "us-east"}, count},
+		{"uptime", nil, count,
+			"uptime", map[string]string{"env": "prod", "dc": "us-east"}, count},
+		{"trailing.dot.", nil, count,
+			"trailing_dot", map[string]string{"env": "prod", "dc": "us-east"}, count},
+	}
+	for _, tc := range cases {
+		metric := decode(&Metric{Name: tc.in, Labels: tc.labels, Fields: tc.fields})
+		assert.Equal(tc.name, metric.Name, tc.in)
+		assert.Equal(tc.out, metric.Labels, tc.in)
+		assert.Equal(tc.outf, metric.Fields, tc.in)
+	}
+}
+
+func TestTemplateNoMatch(t *testing.T) {
+	assert := assert.New(t)
+	decode, err := NewTemplateReshape(TemplateRules{Templates: []string{"req.* measurement.host"}})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	metric := decode(&Metric{Name: "db.users", Fields: map[string]Value{"count": IntValue(1)}})
+	assert.Equal("db.users", metric.Name)
+	assert.Nil(metric.Labels)
+	metric = decode(&Metric{Name: "req.node1"})
+	assert.Equal("req", metric.Name)
+	assert.Equal(map[string]string{"host": "node1"}, metric.Labels)
+}
+
+func TestTemplateInvalid(t *testing.T) {
+	assert := assert.New(t)
+	for _, templates := range [][]string{
+		{"*.measurement"},
+		{"measurement.*"},
+		{"measurement*.host"},
+		{"req.* measurement.field*.host"},
+		{"req.[ measurement"},
+		{"req.* measurement env"},
+		{"a b c d"},
+		{"req.* measurement =x"},
+		{"req.* measurement", "req.* measurement.host"},
+		{"measurement", "measurement.host"},
+	} {
+		_, err := NewTemplateReshape(TemplateRules{Templates: templates})
+		assert.Error(err, "%v", templates)
+	}
+}
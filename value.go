@@ -0,0 +1,204 @@
+package exporters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Kind of field value
+type ValueKind uint8
+
+const (
+	KindFloat ValueKind = iota
+	KindInt
+	KindUint
+	KindBool
+	KindString
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case KindFloat:
+		return "float"
+	case KindInt:
+		return "int"
+	case KindUint:
+		return "uint"
+	case KindBool:
+		return "bool"
+	case KindString:
+		return "string"
+	}
+	return "unknown"
+}
+
+// A Value is a typed field value, one of float64, int64, uint64, bool and
+// string. Zero value is float 0.
+type Value struct {
+	kind ValueKind
+	num  uint64 // bits of float64, int64, uint64 or bool
+	str  string
+}
+
+func FloatValue(f float64) Value {
+	return Value{kind: KindFloat, num: math.Float64bits(f)}
+}
+
+func IntValue(i int64) Value {
+	return Value{kind: KindInt, num: uint64(i)}
+}
+
+func UintValue(u uint64) Value {
+	return Value{kind: KindUint, num: u}
+}
+
+func BoolValue(b bool) Value {
+	v := Value{kind: KindBool}
+	if b {
+		v.num = 1
+	}
+	return v
+}
+
+func StringValue(s string) Value {
+	return Value{kind: KindString, str: s}
+}
+
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+// Whether value is number, including bool as 1 or 0.
+func (v Value) IsNumeric() bool {
+	return v.kind != KindString
+}
+
+// Value as float64, bool is converted to 1 or 0, and string is parsed, or NaN
+// if not a number.
+func (v Value) Float() float64 {
+	switch v.kind {
+	case KindFloat:
+		return math.Float64frombits(v.num)
+	case KindInt:
+		return float64(int64(v.num))
+	case KindUint, KindBool:
+		return float64(v.num)
+	}
+	f, err := strconv.ParseFloat(v.str, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// Value as int64, float is truncated, and string is parsed, or 0 if not an
+// integer.
+func (v Value) Int() int64 {
+	switch v.kind {
+	case KindFloat:
+		return int64(math.Float64frombits(v.num))
+	case KindInt, KindUint, KindBool:
+		return int64(v.num)
+	}
+	i, _ := strconv.ParseInt(v.str, 10, 64)
+	return i
+}
+
+// Value as uint64, float is truncated, and string is parsed, or 0 if not an
+// unsigned integer.
+func (v Value) Uint() uint64 {
+	switch v.kind {
+	case KindFloat:
+		return uint64(math.Float64frombits(v.num))
+	case KindInt, KindUint, KindBool:
+		return v.num
+	}
+	u, _ := strconv.ParseUint(v.str, 10, 64)
+	return u
+}
+
+// Value as bool, number is true if non-zero, and string is parsed.
+func (v Value) Bool() bool {
+	switch v.kind {
+	case KindFloat:
+		return math.Float64frombits(v.num) != 0
+	case KindInt, KindUint, KindBool:
+		return v.num != 0
+	}
+	b, _ := strconv.ParseBool(v.str)
+	return b
+}
+
+// Format value, e.g. 1.5, 5, true, or string as is.
+func (v Value) String() string {
+	switch v.kind {
+	case KindFloat:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case KindInt:
+		return strconv.FormatInt(v.Int(), 10)
+	case KindUint:
+		return strconv.FormatUint(v.num, 10)
+	case KindBool:
+		return strconv.FormatBool(v.num != 0)
+	}
+	return v.str
+}
+
+// Encode value as json number, bool or string. Float is always encoded with
+// fraction or exponent, e.g. 5.0, so that it decodes as float again.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.kind {
+	case KindFloat:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("unsupported float value: %g", f)
+		}
+		b := strconv.AppendFloat(nil, f, 'g', -1, 64)
+		if !bytes.ContainsAny(b, ".e") {
+			b = append(b, '.', '0')
+		}
+		return b, nil
+	case KindString:
+		return json.Marshal(v.str)
+	}
+	return []byte(v.String()), nil
+}
+
+// Decode json number, bool or string. Number with fraction or exponent is
+// decoded as float, otherwise as int, or uint if it overflows int64. Null is
+// a no-op.
+func (v *Value) UnmarshalJSON(b []byte) error {
+	switch {
+	case bytes.Equal(b, []byte("null")):
+	case bytes.Equal(b, []byte("true")):
+		*v = BoolValue(true)
+	case bytes.Equal(b, []byte("false")):
+		*v = BoolValue(false)
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*v = StringValue(s)
+	case bytes.ContainsAny(b, ".eE"):
+		f, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return err
+		}
+		*v = FloatValue(f)
+	default:
+		if i, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			*v = IntValue(i)
+			return nil
+		}
+		u, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid field value: %s", b)
+		}
+		*v = UintValue(u)
+	}
+	return nil
+}
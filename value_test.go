@@ -0,0 +1,49 @@
+package exporters
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueJSON(t *testing.T) {
+	assert := assert.New(t)
+	fields := map[string]Value{
+		"float":  FloatValue(5),
+		"small":  FloatValue(1e-7),
+		"int":    IntValue(-5),
+		"uint":   UintValue(math.MaxUint64),
+		"bool":   BoolValue(true),
+		"string": StringValue(`a "b"`),
+	}
+	bstr, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(`{"bool":true,"float":5.0,"int":-5,"small":1e-07,"string":"a \"b\"","uint":18446744073709551615}`, string(bstr))
+	var decoded map[string]Value
+	if err := json.Unmarshal(bstr, &decoded); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	assert.Equal(fields, decoded)
+
+	_, err = json.Marshal(FloatValue(math.NaN()))
+	assert.Error(err)
+	var v Value
+	assert.Error(json.Unmarshal([]byte(`[1]`), &v))
+}
+
+func TestValueConvert(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(1.0, BoolValue(true).Float())
+	assert.Equal(int64(2), FloatValue(2.7).Int())
+	assert.Equal(-3.0, IntValue(-3).Float())
+	assert.Equal(2.5, StringValue("2.5").Float())
+	assert.True(math.IsNaN(StringValue("x").Float()))
+	assert.True(IntValue(1).Bool())
+	assert.False(StringValue("x").IsNumeric())
+	assert.Equal("1.5", FloatValue(1.5).String())
+	assert.Equal(KindFloat, Value{}.Kind())
+}